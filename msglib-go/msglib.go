@@ -1,12 +1,14 @@
 package msglib
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"strings"
 )
 
 const (
@@ -26,6 +28,23 @@ const (
 	MT_SET    byte = 14
 )
 
+var typeNames = map[byte]string{
+	MT_NULL:   "MT_NULL",
+	MT_BOOL:   "MT_BOOL",
+	MT_BYTE:   "MT_BYTE",
+	MT_I16:    "MT_I16",
+	MT_I32:    "MT_I32",
+	MT_I64:    "MT_I64",
+	MT_FLOAT:  "MT_FLOAT",
+	MT_DOUBLE: "MT_DOUBLE",
+	MT_BINARY: "MT_BINARY",
+	MT_STRING: "MT_STRING",
+	MT_STRUCT: "MT_STRUCT",
+	MT_MAP:    "MT_MAP",
+	MT_LIST:   "MT_LIST",
+	MT_SET:    "MT_SET",
+}
+
 type MField struct {
 	Name string
 	Type byte
@@ -117,6 +136,7 @@ type mBinaryProto struct {
 	writeBuffer []byte
 	readBuffer  []byte
 	oneByte     []byte
+	opts        *DecodeOptions
 }
 
 func NewBinaryProto() IMProto {
@@ -151,6 +171,9 @@ func (r *mByteReader) ReadByte() (byte, error) {
 }
 
 func (bin *mBinaryProto) readVarint(reader io.Reader) (int64, error) {
+	if sr, ok := reader.(*sliceReader); ok {
+		return sr.readVarint()
+	}
 	if br, ok := reader.(io.ByteReader); ok {
 		return binary.ReadVarint(br)
 	}
@@ -159,6 +182,9 @@ func (bin *mBinaryProto) readVarint(reader io.Reader) (int64, error) {
 }
 
 func (bin *mBinaryProto) readUvarint(reader io.Reader) (uint64, error) {
+	if sr, ok := reader.(*sliceReader); ok {
+		return sr.readUvarint()
+	}
 	if br, ok := reader.(io.ByteReader); ok {
 		return binary.ReadUvarint(br)
 	}
@@ -166,6 +192,20 @@ func (bin *mBinaryProto) readUvarint(reader io.Reader) (uint64, error) {
 	return binary.ReadUvarint(br)
 }
 
+// readFixed reads n bytes of a fixed size value, n <= len(readBuffer)
+func (bin *mBinaryProto) readFixed(reader io.Reader, n int) ([]byte, error) {
+	if sr, ok := reader.(*sliceReader); ok {
+		buf, err := sr.next(uint64(n))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	buf := bin.readBuffer[:n]
+	_, err := io.ReadFull(reader, buf)
+	return buf, err
+}
+
 func (bin *mBinaryProto) writeVarint(writer io.Writer, val int64) error {
 	n := binary.PutVarint(bin.writeBuffer, val)
 	_, err := writer.Write(bin.writeBuffer[:n])
@@ -178,6 +218,50 @@ func (bin *mBinaryProto) writeUvarint(writer io.Writer, val uint64) error {
 	return err
 }
 
+func (bin *mBinaryProto) setDecodeOptions(opts *DecodeOptions) {
+	bin.opts = opts
+}
+
+// readBytesChunkSize bounds memory allocated ahead of data actually read,
+// so a forged length does not allocate more than the payload carries.
+const readBytesChunkSize = 64 * 1024
+
+func (bin *mBinaryProto) checkBytes(cnt uint64) error {
+	if cnt > math.MaxInt32 {
+		return &LimitError{Limit: "MaxBinaryLength", Max: math.MaxInt32, Value: int64(cnt & math.MaxInt64)}
+	}
+	return bin.opts.checkLength(int(cnt))
+}
+
+func (bin *mBinaryProto) readBytes(reader io.Reader, cnt uint64) ([]byte, error) {
+	if err := bin.checkBytes(cnt); err != nil {
+		return nil, err
+	}
+	if sr, ok := reader.(*sliceReader); ok {
+		buf, err := sr.next(cnt)
+		if err != nil || bin.opts.aliasBytes() {
+			return buf, err
+		}
+		return append([]byte(nil), buf...), nil
+	}
+	if cnt <= readBytesChunkSize {
+		buf := make([]byte, cnt)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, readBytesChunkSize))
+	n, err := io.CopyN(buf, reader, int64(cnt))
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func (bin *mBinaryProto) ReadStructBegin(reader io.Reader) (*MStruct, error) {
 	return nil, nil
 }
@@ -186,13 +270,20 @@ func (bin *mBinaryProto) WriteStructBegin(writer io.Writer, marker *MStruct) err
 	return nil
 }
 
-func (bin *mBinaryProto) ReadFieldBegin(reader io.Reader) (field *MField, err error) {
+func (bin *mBinaryProto) ReadFieldBegin(reader io.Reader) (*MField, error) {
+	field, err := bin.readField(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &field, nil
+}
+
+func (bin *mBinaryProto) readField(reader io.Reader) (field MField, err error) {
 	val, err := bin.readUvarint(reader)
 	if err != nil {
 		return
 	}
 	idAndType := int32(val)
-	field = &MField{}
 	field.Type = byte(idAndType & 0x0F)
 	field.ID = int(idAndType >> 4)
 	return
@@ -210,19 +301,29 @@ func (bin *mBinaryProto) WriteFieldStop(writer io.Writer) error {
 }
 
 func (bin *mBinaryProto) ReadMapBegin(reader io.Reader) (*MMap, error) {
-	val, err := bin.readUvarint(reader)
+	marker, err := bin.readMap(reader)
 	if err != nil {
 		return nil, err
 	}
-	marker := &MMap{}
-	marker.Count = int(val)
+	if err = bin.opts.checkCount(marker.Count); err != nil {
+		return nil, err
+	}
+	return &marker, nil
+}
+
+func (bin *mBinaryProto) readMap(reader io.Reader) (marker MMap, err error) {
+	val, err := bin.readUvarint(reader)
+	if err != nil {
+		return
+	}
 	tval, err := bin.ReadByte(reader)
 	if err != nil {
-		return nil, err
+		return
 	}
+	marker.Count = int(val)
 	marker.KeyType = byte(tval & 0x0F)
 	marker.ValueType = byte(tval >> 4)
-	return marker, nil
+	return
 }
 
 func (bin *mBinaryProto) WriteMapBegin(writer io.Writer, marker *MMap) error {
@@ -238,15 +339,25 @@ func (bin *mBinaryProto) WriteMapBegin(writer io.Writer, marker *MMap) error {
 }
 
 func (bin *mBinaryProto) ReadListBegin(reader io.Reader) (*MList, error) {
-	val, err := bin.readUvarint(reader)
+	list, err := bin.readList(reader)
 	if err != nil {
 		return nil, err
 	}
+	if err = bin.opts.checkCount(list.Count); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (bin *mBinaryProto) readList(reader io.Reader) (list MList, err error) {
+	val, err := bin.readUvarint(reader)
+	if err != nil {
+		return
+	}
 	countAndType := int32(val)
-	list := &MList{}
 	list.ElementType = byte(countAndType & 0x0F)
 	list.Count = int(countAndType >> 4)
-	return list, nil
+	return
 }
 
 func (bin *mBinaryProto) WriteListBegin(writer io.Writer, marker *MList) error {
@@ -256,10 +367,13 @@ func (bin *mBinaryProto) WriteListBegin(writer io.Writer, marker *MList) error {
 }
 
 func (bin *mBinaryProto) ReadSetBegin(reader io.Reader) (*MSet, error) {
-	list, err := bin.ReadListBegin(reader)
+	list, err := bin.readList(reader)
 	if err != nil {
 		return nil, err
 	}
+	if err = bin.opts.checkCount(list.Count); err != nil {
+		return nil, err
+	}
 	set := &MSet{ElementType: list.ElementType, Count: list.Count}
 	return set, err
 }
@@ -287,6 +401,10 @@ func (bin *mBinaryProto) WriteBool(writer io.Writer, data bool) error {
 }
 
 func (bin *mBinaryProto) ReadByte(reader io.Reader) (byte, error) {
+	if sr, ok := reader.(*sliceReader); ok {
+		c, err := sr.ReadByte()
+		return c, err
+	}
 	onebyte := bin.readBuffer[:1]
 	_, err := io.ReadFull(reader, onebyte)
 	return onebyte[0], err
@@ -301,6 +419,9 @@ func (bin *mBinaryProto) WriteByte(writer io.Writer, data byte) error {
 
 func (bin *mBinaryProto) ReadI16(reader io.Reader) (int16, error) {
 	val, err := bin.readVarint(reader)
+	if err == nil && (val < math.MinInt16 || val > math.MaxInt16) {
+		return 0, &OverflowError{Value: val, Type: typeNames[MT_I16]}
+	}
 	return int16(val), err
 }
 
@@ -310,6 +431,9 @@ func (bin *mBinaryProto) WriteI16(writer io.Writer, data int16) error {
 
 func (bin *mBinaryProto) ReadI32(reader io.Reader) (int32, error) {
 	val, err := bin.readVarint(reader)
+	if err == nil && (val < math.MinInt32 || val > math.MaxInt32) {
+		return 0, &OverflowError{Value: val, Type: typeNames[MT_I32]}
+	}
 	return int32(val), err
 }
 
@@ -327,10 +451,11 @@ func (bin *mBinaryProto) WriteI64(writer io.Writer, data int64) error {
 }
 
 func (bin *mBinaryProto) ReadFloat32(reader io.Reader) (float32, error) {
-	buf := bin.readBuffer[0:4]
-	_, err := io.ReadFull(reader, buf)
-	val := math.Float32frombits(binary.LittleEndian.Uint32(buf))
-	return val, err
+	buf, err := bin.readFixed(reader, 4)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(buf)), nil
 }
 
 func (bin *mBinaryProto) WriteFloat32(writer io.Writer, data float32) error {
@@ -341,10 +466,11 @@ func (bin *mBinaryProto) WriteFloat32(writer io.Writer, data float32) error {
 }
 
 func (bin *mBinaryProto) ReadFloat64(reader io.Reader) (float64, error) {
-	buf := bin.readBuffer[0:8]
-	_, err := io.ReadFull(reader, buf)
-	val := math.Float64frombits(binary.LittleEndian.Uint64(buf))
-	return val, err
+	buf, err := bin.readFixed(reader, 8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
 }
 
 func (bin *mBinaryProto) WriteFloat64(writer io.Writer, data float64) error {
@@ -361,11 +487,7 @@ func (bin *mBinaryProto) ReadBinary(reader io.Reader) ([]byte, error) {
 	} else if cnt < 0 {
 		return nil, errors.New("negative length in msglib.BinaryProto.ReadBinary")
 	}
-	buf := make([]byte, cnt)
-	if _, err := io.ReadFull(reader, buf); err != nil {
-		return nil, err
-	}
-	return buf, nil
+	return bin.readBytes(reader, cnt)
 }
 
 func (bin *mBinaryProto) WriteBinary(writer io.Writer, binary []byte) error {
@@ -384,15 +506,27 @@ func (bin *mBinaryProto) ReadString(reader io.Reader) (string, error) {
 	} else if cnt < 0 {
 		return "", errors.New("negative length in msglib.BinaryProto.ReadBinary")
 	}
+	if sr, ok := reader.(*sliceReader); ok {
+		if err := bin.checkBytes(cnt); err != nil {
+			return "", err
+		}
+		buf, err := sr.next(cnt)
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
 	// use readbuffer to optimize
 	buf := bin.readBuffer
-	if int(cnt) > len(buf) {
-		buf = make([]byte, cnt)
+	if cnt > uint64(len(buf)) {
+		if buf, err = bin.readBytes(reader, cnt); err != nil {
+			return "", err
+		}
 	} else {
 		buf = buf[:cnt]
-	}
-	if _, err := io.ReadFull(reader, buf); err != nil {
-		return "", err
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return "", err
+		}
 	}
 	return string(buf), nil
 }
@@ -418,3 +552,39 @@ type UnsupportedTypeError struct {
 func (e *UnsupportedTypeError) Error() string {
 	return fmt.Sprintf("msglib: unsupported type: %+v", e.Type)
 }
+
+type DuplicateFieldError struct {
+	Type   reflect.Type
+	ID     int
+	Fields []string
+}
+
+func (e *DuplicateFieldError) Error() string {
+	return fmt.Sprintf("msglib: duplicate field id %d in %+v: %s", e.ID, e.Type, strings.Join(e.Fields, ", "))
+}
+
+// MissingFieldError is returned when required fields are absent from a decoded
+// message, or unset in a message to encode
+type MissingFieldError struct {
+	Struct string // struct type name
+	IDs    []int
+	Fields []string
+}
+
+func (e *MissingFieldError) Error() string {
+	missing := make([]string, len(e.IDs))
+	for i, id := range e.IDs {
+		missing[i] = fmt.Sprintf("%d (%s)", id, e.Fields[i])
+	}
+	return fmt.Sprintf("msglib: missing required fields of %s: %s", e.Struct, strings.Join(missing, ", "))
+}
+
+// OverflowError is returned when an integer does not fit into the wire type or the go type
+type OverflowError struct {
+	Value interface{}
+	Type  string
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("msglib: value %v overflows %s", e.Value, e.Type)
+}
@@ -0,0 +1,174 @@
+package msglib
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// compressed envelope: messages of Serialize, Encoder and MsgCodec at least
+// EncodeOptions.CompressThreshold long are written as
+//
+//   [envelopeFlag][codec][compressed message]
+//
+// The flag has type nibble 15, which no field header has, so Deserialize, Decoder
+// and MsgCodec tell envelopes from plain messages by their first byte and
+// decompress them transparently. EncodeStruct and DecodeStruct never use envelopes,
+// neither do messages of a Marshaler whose Typer reports a type other than MT_STRUCT.
+
+const envelopeFlag = 0x0F
+
+// Compression codecs, the codec byte of an envelope
+const (
+	CompressNone  byte = 0
+	CompressFlate byte = 1 // raw deflate, compress/flate
+	CompressGzip  byte = 2 // compress/gzip, with header and checksum
+)
+
+const (
+	DefaultCompressThreshold    = 1024
+	DefaultMaxDecompressedBytes = 64 << 20
+)
+
+func (opts *EncodeOptions) compression() byte {
+	if opts == nil {
+		return CompressNone
+	}
+	return opts.Compression
+}
+
+func (opts *EncodeOptions) compressThreshold() int {
+	if opts == nil || opts.CompressThreshold <= 0 {
+		return DefaultCompressThreshold
+	}
+	return opts.CompressThreshold
+}
+
+func (opts *DecodeOptions) maxDecompressedBytes() int64 {
+	if opts == nil {
+		return DefaultMaxDecompressedBytes
+	}
+	if opts.MaxDecompressedBytes == 0 {
+		// a message longer than MaxBytes fails decoding anyway
+		if opts.MaxBytes > 0 {
+			return opts.MaxBytes
+		}
+		return DefaultMaxDecompressedBytes
+	}
+	return opts.MaxDecompressedBytes
+}
+
+var (
+	flateWriterPool sync.Pool
+	gzipWriterPool  sync.Pool
+	flateReaderPool sync.Pool
+	gzipReaderPool  sync.Pool
+)
+
+// compress appends the envelope of msg to dst. ok is false, and dst returned unchanged,
+// when compression is off, msg is shorter than the threshold or does not shrink.
+func (opts *EncodeOptions) compress(dst, msg []byte) (res []byte, ok bool, err error) {
+	codec := opts.compression()
+	if codec == CompressNone || len(msg) < opts.compressThreshold() {
+		return dst, false, nil
+	}
+	writer := &appendWriter{buf: append(dst, envelopeFlag, codec)}
+	switch codec {
+	case CompressFlate:
+		zw, _ := flateWriterPool.Get().(*flate.Writer)
+		if zw == nil {
+			zw, _ = flate.NewWriter(writer, flate.DefaultCompression)
+		} else {
+			zw.Reset(writer)
+		}
+		defer flateWriterPool.Put(zw)
+		if _, err = zw.Write(msg); err == nil {
+			err = zw.Close()
+		}
+	case CompressGzip:
+		zw, _ := gzipWriterPool.Get().(*gzip.Writer)
+		if zw == nil {
+			zw = gzip.NewWriter(writer)
+		} else {
+			zw.Reset(writer)
+		}
+		defer gzipWriterPool.Put(zw)
+		if _, err = zw.Write(msg); err == nil {
+			err = zw.Close()
+		}
+	default:
+		return dst, false, fmt.Errorf("msglib: unknown compression codec %d", codec)
+	}
+	if err != nil {
+		return dst, false, err
+	}
+	if len(writer.buf)-len(dst) >= len(msg) {
+		return dst, false, nil
+	}
+	return writer.buf, true, nil
+}
+
+// isEnvelope reports whether payload is a compressed envelope rather than a message
+func isEnvelope(payload []byte) bool {
+	return len(payload) > 0 && payload[0] == envelopeFlag
+}
+
+// hasEnvelope reports whether messages of data may be compressed. Only struct messages
+// can, a Marshaler writing another MT_* type may start with the envelope flag.
+func hasEnvelope(data interface{}) bool {
+	t := reflect.TypeOf(data)
+	return t != nil && fieldType(t) == MT_STRUCT
+}
+
+// openEnvelope returns the message of an envelope, decompressing at most MaxDecompressedBytes
+func (opts *DecodeOptions) openEnvelope(payload []byte) ([]byte, error) {
+	if len(payload) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	codec := payload[1]
+	reader := bytes.NewReader(payload[2:])
+	var zr io.Reader
+	switch codec {
+	case CompressFlate:
+		fr, _ := flateReaderPool.Get().(io.ReadCloser)
+		if fr == nil {
+			fr = flate.NewReader(reader)
+		} else if err := fr.(flate.Resetter).Reset(reader, nil); err != nil {
+			return nil, err
+		}
+		defer flateReaderPool.Put(fr)
+		zr = fr
+	case CompressGzip:
+		gr, _ := gzipReaderPool.Get().(*gzip.Reader)
+		if gr == nil {
+			var err error
+			if gr, err = gzip.NewReader(reader); err != nil {
+				return nil, err
+			}
+		} else if err := gr.Reset(reader); err != nil {
+			gzipReaderPool.Put(gr)
+			return nil, err
+		}
+		defer gzipReaderPool.Put(gr)
+		zr = gr
+	default:
+		return nil, fmt.Errorf("msglib: unknown compression codec %d", codec)
+	}
+
+	max := opts.maxDecompressedBytes()
+	if max > 0 {
+		zr = io.LimitReader(zr, max+1)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(zr); err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(buf.Len()) > max {
+		return nil, &LimitError{Limit: "MaxDecompressedBytes", Max: max, Value: int64(buf.Len())}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,109 @@
+package msglib
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMsglibCompress(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: 2820}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: bytes.Repeat([]byte("hello "), 500)}
+	plain, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	for _, codec := range []byte{CompressFlate, CompressGzip} {
+		opts := &EncodeOptions{Compression: codec}
+		payload, err := opts.Serialize(obj)
+		if err != nil {
+			t.Fatalf("serialize object failure: %+v", err)
+		}
+		if !isEnvelope(payload) || payload[1] != codec || len(payload) >= len(plain) {
+			t.Fatalf("data err, expect envelope of codec %v, got % x", codec, payload[:2])
+		}
+		var res msgTest1
+		if err = Deserialize(payload, &res); err != nil {
+			t.Fatalf("deserialize object failure: %+v", err)
+		}
+		if !reflect.DeepEqual(&res, obj) {
+			t.Fatalf("data err, expect %+v, got %+v", obj, &res)
+		}
+
+		// the limit applies to the decompressed message
+		err = (&DecodeOptions{MaxDecompressedBytes: 1000}).Deserialize(payload, &res)
+		if !isLimitError(err, "MaxDecompressedBytes") {
+			t.Fatalf("expect MaxDecompressedBytes exceeded, got %v", err)
+		}
+		// and defaults to MaxBytes
+		err = (&DecodeOptions{MaxBytes: 1000}).Deserialize(payload, &res)
+		if le, ok := err.(*LimitError); !ok || le.Limit != "MaxDecompressedBytes" || le.Max != 1000 {
+			t.Fatalf("expect MaxDecompressedBytes of MaxBytes exceeded, got %v", err)
+		}
+	}
+
+	// short messages are written plain
+	small := &msgTest1{Name: "xixi"}
+	payload, err := (&EncodeOptions{Compression: CompressGzip}).Serialize(small)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if expect, _ := Serialize(small); !bytes.Equal(payload, expect) {
+		t.Fatalf("data err, expect % x, got % x", expect, payload)
+	}
+
+	// streams mix compressed and plain frames
+	buff := &bytes.Buffer{}
+	enc := NewEncoder(buff)
+	enc.SetOptions(&EncodeOptions{Compression: CompressFlate, CompressThreshold: 100})
+	for _, o := range []*msgTest1{obj, small, obj} {
+		if err = enc.Encode(o); err != nil {
+			t.Fatalf("encode failure: %+v", err)
+		}
+	}
+	if buff.Len() >= 2*len(plain) {
+		t.Fatalf("data err, expect compressed frames, got %v bytes", buff.Len())
+	}
+	dec := NewDecoder(buff)
+	for _, expect := range []*msgTest1{obj, small, obj} {
+		var res msgTest1
+		if err = dec.Decode(&res); err != nil {
+			t.Fatalf("decode failure: %+v", err)
+		}
+		if !reflect.DeepEqual(&res, expect) {
+			t.Fatalf("data err, expect %+v, got %+v", expect, &res)
+		}
+	}
+
+	// messages of a Marshaler other than a struct are never envelopes,
+	// even when they start with the envelope flag
+	stamp := &msgTestStamp{Unix: 100000000000000}
+	opts := &EncodeOptions{Compression: CompressGzip, CompressThreshold: 1}
+	payload, err = opts.Serialize(stamp)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if payload[0] != envelopeFlag {
+		t.Fatalf("data err, expect plain string of 15 bytes, got % x", payload)
+	}
+	var stamp2 msgTestStamp
+	if err = Deserialize(payload, &stamp2); err != nil || stamp2 != *stamp {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", stamp, stamp2, err)
+	}
+	buff.Reset()
+	enc.SetOptions(opts)
+	if err = enc.Encode(stamp); err != nil {
+		t.Fatalf("encode failure: %+v", err)
+	}
+	stamp2 = msgTestStamp{}
+	if err = NewDecoder(buff).Decode(&stamp2); err != nil || stamp2 != *stamp {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", stamp, stamp2, err)
+	}
+
+	if _, err = (&EncodeOptions{Compression: 9}).Serialize(obj); err == nil {
+		t.Fatalf("expect unknown codec failure")
+	}
+	if err = Deserialize([]byte{envelopeFlag, 9, 0}, &msgTest1{}); err == nil {
+		t.Fatalf("expect unknown codec failure")
+	}
+}
@@ -0,0 +1,143 @@
+package msglib
+
+import (
+	"io"
+	"reflect"
+	"sort"
+)
+
+// EncodeOptions controls encoding, a nil *EncodeOptions encodes like EncodeStruct.
+//
+// Struct fields are always written in ascending id order. With Deterministic set,
+// map entries and map backed sets are written in ascending key order too, so equal
+// values encode to identical bytes. Keys are ordered by value: false before true,
+// numbers numerically with NaN first, strings bytewise, arrays and structs element
+// by element, pointers and interfaces by the value they hold with nil first.
+// Slice backed sets keep the order of the slice, and Marshaler implementations
+// write their own order.
+type EncodeOptions struct {
+	Deterministic bool
+
+	// Compression is the codec of compressed envelopes written by Serialize, AppendSerialize,
+	// Encoder and MsgCodec for messages of CompressThreshold bytes or more,
+	// zero means DefaultCompressThreshold. Messages that do not shrink are written plain.
+	Compression       byte
+	CompressThreshold int
+}
+
+// EncodeStruct is EncodeStruct with options of opts
+func (opts *EncodeOptions) EncodeStruct(w io.Writer, proto IMProto, data interface{}) error {
+	return encodeStruct(w, proto, data, opts)
+}
+
+// Serialize is Serialize with options of opts
+func (opts *EncodeOptions) Serialize(data interface{}) ([]byte, error) {
+	return appendSerialize(nil, data, opts)
+}
+
+// AppendSerialize is AppendSerialize with options of opts
+func (opts *EncodeOptions) AppendSerialize(dst []byte, data interface{}) ([]byte, error) {
+	return appendSerialize(dst, data, opts)
+}
+
+func (opts *EncodeOptions) deterministic() bool {
+	return opts != nil && opts.Deterministic
+}
+
+// mapKeys returns keys of map val, sorted in deterministic mode
+func (enc *encoder) mapKeys(val reflect.Value) []reflect.Value {
+	keys := val.MapKeys()
+	if enc.opts.deterministic() {
+		sort.Slice(keys, func(i, j int) bool { return compareValues(keys[i], keys[j]) < 0 })
+	}
+	return keys
+}
+
+// compareValues orders two values of the same type, returning -1, 0 or 1
+func compareValues(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Bool:
+		return compareBool(a.Bool(), b.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareInt(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return compareUint(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareFloat(a.Float(), b.Float())
+	case reflect.String:
+		return compareString(a.String(), b.String())
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compareValues(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compareValues(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return compareBool(!a.IsNil(), !b.IsNil())
+		}
+		a, b = a.Elem(), b.Elem()
+		if a.Type() != b.Type() {
+			return compareString(a.Type().String(), b.Type().String())
+		}
+		return compareValues(a, b)
+	}
+	return 0
+}
+
+func compareBool(a, b bool) int {
+	if a == b {
+		return 0
+	} else if b {
+		return -1
+	}
+	return 1
+}
+
+func compareInt(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareUint(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat(a, b float64) int {
+	if a != a || b != b {
+		// NaN sorts first
+		return compareBool(a == a, b == b)
+	}
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareString(a, b string) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
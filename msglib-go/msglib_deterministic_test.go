@@ -0,0 +1,91 @@
+package msglib
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type msgTestKey struct {
+	Shard int32  `msglib:"1"`
+	Name  string `msglib:"2"`
+}
+
+type msgTestMaps struct {
+	Tail    int32                 `msglib:"9"`
+	Names   map[string]int32      `msglib:"1"`
+	Ids     map[int64]string      `msglib:"2"`
+	Hashes  map[[2]byte]bool      `msglib:"3"`
+	Weights map[float64]int32     `msglib:"4"`
+	Keys    map[msgTestKey]string `msglib:"5"`
+	Members map[uint16]struct{}   `msglib:"6,set"`
+}
+
+func newMsgTestMaps(n int) *msgTestMaps {
+	obj := &msgTestMaps{
+		Tail:    1,
+		Names:   map[string]int32{},
+		Ids:     map[int64]string{},
+		Hashes:  map[[2]byte]bool{},
+		Weights: map[float64]int32{},
+		Keys:    map[msgTestKey]string{},
+		Members: map[uint16]struct{}{},
+	}
+	for i := 0; i < n; i++ {
+		obj.Names[string(rune('a'+i))] = int32(i)
+		obj.Ids[int64(i*7919%n-n/2)] = "x"
+		obj.Hashes[[2]byte{byte(i), byte(n - i)}] = i%2 == 0
+		obj.Weights[float64(i)/3-2] = int32(i)
+		obj.Keys[msgTestKey{Shard: int32(i % 3), Name: string(rune('z' - i))}] = "k"
+		obj.Members[uint16(i*31)] = struct{}{}
+	}
+	return obj
+}
+
+func TestDeterministicEncoding(t *testing.T) {
+	opts := &EncodeOptions{Deterministic: true}
+	expect, err := opts.Serialize(newMsgTestMaps(20))
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	for i := 0; i < 10; i++ {
+		payload, err := opts.Serialize(newMsgTestMaps(20))
+		if err != nil {
+			t.Fatalf("serialize object failure: %+v", err)
+		}
+		if !bytes.Equal(payload, expect) {
+			t.Fatalf("data err, expect identical payloads, got %v and %v", expect, payload)
+		}
+	}
+
+	// fields are written in ascending id order
+	if expect[0] != byte(1<<4|MT_MAP) {
+		t.Fatalf("data err, expect field 1 first, got header %v", expect[0])
+	}
+
+	var obj msgTestMaps
+	if err = Deserialize(expect, &obj); err != nil || !reflect.DeepEqual(&obj, newMsgTestMaps(20)) {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", newMsgTestMaps(20), &obj, err)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	floats := []float64{3, math.Inf(-1), math.NaN(), -1, math.Inf(1), 0}
+	sort.Slice(floats, func(i, j int) bool {
+		return compareValues(reflect.ValueOf(floats[i]), reflect.ValueOf(floats[j])) < 0
+	})
+	if !math.IsNaN(floats[0]) || floats[1] != math.Inf(-1) || floats[5] != math.Inf(1) {
+		t.Fatalf("data err, got %v", floats)
+	}
+
+	keys := []interface{}{"b", nil, int32(2), "a", int32(-1)}
+	sort.Slice(keys, func(i, j int) bool {
+		return compareValues(reflect.ValueOf(&keys[i]).Elem(), reflect.ValueOf(&keys[j]).Elem()) < 0
+	})
+	expect := []interface{}{nil, int32(-1), int32(2), "a", "b"}
+	if !reflect.DeepEqual(keys, expect) {
+		t.Fatalf("data err, expect %v, got %v", expect, keys)
+	}
+}
@@ -0,0 +1,172 @@
+package msglib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Kinds of DecodeError, to test with errors.Is
+var (
+	ErrTruncated     = errors.New("msglib: truncated message")
+	ErrTypeMismatch  = errors.New("msglib: type mismatch")
+	ErrLimitExceeded = errors.New("msglib: limit exceeded")
+	ErrOverflow      = errors.New("msglib: integer overflow")
+)
+
+// DecodeError is returned by DecodeStruct and Deserialize, telling where decoding failed.
+// errors.Is matches its Kind and the errors it wraps, errors.As finds the wrapped
+// LimitError, OverflowError, MissingFieldError or UnsupportedValueError.
+type DecodeError struct {
+	Path     string // go path of the value, e.g. msgTest1.TagList[1].Hash, elements are numbered in message order
+	Offset   int64  // bytes read from the start of the message when decoding failed
+	Expected byte   // MT_* type of the go value for ErrTypeMismatch, 0 otherwise
+	Actual   byte   // MT_* type on the wire for ErrTypeMismatch, 0 otherwise
+	Kind     error  // ErrTruncated, ErrTypeMismatch, ErrLimitExceeded, ErrOverflow or nil
+	Err      error  // the underlying error
+}
+
+func (e *DecodeError) Error() string {
+	var cause string
+	if e.Kind == ErrTypeMismatch {
+		cause = "type mismatch, expect " + typeNames[e.Expected] + ", got " + typeNames[e.Actual]
+	} else if e.Err != nil {
+		cause = strings.TrimPrefix(e.Err.Error(), "msglib: ")
+	} else if e.Kind != nil {
+		cause = strings.TrimPrefix(e.Kind.Error(), "msglib: ")
+	}
+	return fmt.Sprintf("msglib: decode %s at offset %d: %s", e.Path, e.Offset, cause)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func (e *OverflowError) Is(target error) bool {
+	return target == ErrOverflow
+}
+
+// decodeErrorKind classifies errors of the decoder
+func decodeErrorKind(err error) error {
+	switch err.(type) {
+	case *LimitError:
+		return ErrLimitExceeded
+	case *OverflowError:
+		return ErrOverflow
+	}
+	switch err {
+	case io.ErrUnexpectedEOF:
+		return ErrTruncated
+	case errVarintOverflow:
+		return ErrOverflow
+	}
+	return nil
+}
+
+// pathElem is a struct field or a list, set or map element on the decoder path
+type pathElem struct {
+	field string // field name, empty for elements
+	index int    // element position
+}
+
+func (dec *decoder) pushField(name string) {
+	dec.path = append(dec.path, pathElem{field: name})
+}
+
+func (dec *decoder) pushIndex(i int) {
+	dec.path = append(dec.path, pathElem{index: i})
+}
+
+func (dec *decoder) pop() {
+	dec.path = dec.path[:len(dec.path)-1]
+}
+
+// mismatch is the error of decoding a value of go type local from wire type wire
+func (dec *decoder) mismatch(local, wire byte, err error) error {
+	return &DecodeError{Kind: ErrTypeMismatch, Expected: local, Actual: wire, Err: err}
+}
+
+// decodeError wraps err with the path and offset where the decoder stopped,
+// errors of nested DecodeStruct calls are kept
+func (dec *decoder) decodeError(err error) error {
+	de, ok := err.(*DecodeError)
+	if ok && de.Path != "" {
+		return err
+	}
+	if !ok {
+		if err == io.EOF {
+			// the message ended inside a struct
+			err = io.ErrUnexpectedEOF
+		}
+		de = &DecodeError{Kind: decodeErrorKind(err), Err: err}
+	}
+	sb := &strings.Builder{}
+	sb.WriteString(dec.root)
+	for _, p := range dec.path {
+		if p.field != "" {
+			sb.WriteByte('.')
+			sb.WriteString(p.field)
+		} else {
+			sb.WriteByte('[')
+			sb.WriteString(strconv.Itoa(p.index))
+			sb.WriteByte(']')
+		}
+	}
+	de.Path = sb.String()
+	de.Offset = readerOffset(dec.reader)
+	return de
+}
+
+// countingReader counts bytes read by decoders reading from a stream
+type countingReader struct {
+	reader     io.Reader
+	byteReader io.ByteReader
+	count      int64
+}
+
+func newCountingReader(reader io.Reader) *countingReader {
+	cr := &countingReader{reader: reader}
+	if br, ok := reader.(io.ByteReader); ok {
+		cr.byteReader = br
+	}
+	return cr
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.count += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	if cr.byteReader == nil {
+		var b [1]byte
+		_, err := io.ReadFull(cr, b[:])
+		return b[0], err
+	}
+	b, err := cr.byteReader.ReadByte()
+	if err == nil {
+		cr.count++
+	}
+	return b, err
+}
+
+func readerOffset(reader io.Reader) int64 {
+	switch r := reader.(type) {
+	case *sliceReader:
+		return int64(r.off)
+	case *countingReader:
+		return r.count
+	}
+	return -1
+}
@@ -0,0 +1,70 @@
+package msglib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type msgTestBadTag struct {
+	Hash []byte `msglib:"1"`
+	Val  string `msglib:"2"`
+}
+
+type msgTestBadList struct {
+	Name    string           `msglib:"1"`
+	TagList []*msgTestBadTag `msglib:"4"`
+}
+
+func TestDecodeError(t *testing.T) {
+	payload, err := Serialize(&msgTestBadList{Name: "xixi", TagList: []*msgTestBadTag{{Hash: []byte{1}}, {Val: "x"}}})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var obj msgTest1
+	err = Deserialize(payload, &obj)
+	var de *DecodeError
+	if !errors.As(err, &de) || !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expect DecodeError of type mismatch, got %v", err)
+	}
+	// decoding stops after the field header of Val, before "x" and the stops of the tag and the message
+	if de.Path != "msgTest1.TagList[1].Val" || de.Expected != MT_I32 || de.Actual != MT_STRING || de.Offset != int64(len(payload)-4) {
+		t.Fatalf("data err, expect mismatch at msgTest1.TagList[1].Val, got %+v", de)
+	}
+	expect := "msglib: decode msgTest1.TagList[1].Val at offset 13: type mismatch, expect MT_I32, got MT_STRING"
+	if err.Error() != expect {
+		t.Fatalf("data err, expect %v, got %v", expect, err)
+	}
+
+	// truncated messages, from a stream too
+	payload, err = Serialize(&msgTest1{Name: "xixi", Tag: &msgTest1_Tag{Hash: []byte("hello world")}})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	truncated := payload[:len(payload)-4]
+	err = DecodeStruct(bytes.NewReader(truncated), NewBinaryProto(), &obj)
+	if !errors.As(err, &de) || !errors.Is(err, ErrTruncated) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expect DecodeError of truncated message, got %v", err)
+	}
+	if de.Path != "msgTest1.Tag.Hash" || de.Offset != int64(len(truncated)) {
+		t.Fatalf("data err, expect truncated at msgTest1.Tag.Hash, got %+v", de)
+	}
+
+	// limits and overflows
+	err = (&DecodeOptions{MaxBinaryLength: 4}).Deserialize(payload, &obj)
+	if !errors.As(err, &de) || !errors.Is(err, ErrLimitExceeded) || !isLimitError(err, "MaxBinaryLength") {
+		t.Fatalf("expect DecodeError of exceeded limit, got %v", err)
+	}
+	if de.Path != "msgTest1.Tag.Hash" {
+		t.Fatalf("data err, expect limit at a string, got %+v", de)
+	}
+	payload, err = Serialize(&msgTestInt32{Legacy: 1 << 20})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var small msgTestInt16
+	if err = Deserialize(payload, &small); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+}
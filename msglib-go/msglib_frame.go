@@ -0,0 +1,189 @@
+package msglib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+// sealed frames: with FrameOptions a message is checked before it is decoded
+//
+//   [uvarint key id][message][crc32c][hmac-sha256]
+//
+// The key id and the HMAC are written with Keys only, the big endian CRC32C
+// (Castagnoli) with Checksum only. The CRC covers the key id and the message,
+// the HMAC covers everything before it. Encoder and Decoder seal the payload
+// of every stream frame, the length prefix counts the sealed frame.
+
+// Errors of Open
+var (
+	ErrChecksum  = errors.New("msglib: frame checksum mismatch")
+	ErrSignature = errors.New("msglib: frame signature mismatch")
+)
+
+// ErrNoSigningKey is returned by Seal when the KeyRing has no signing key
+var ErrNoSigningKey = errors.New("msglib: no frame signing key")
+
+// UnknownKeyError is returned when a frame is signed with a key missing from the KeyRing
+type UnknownKeyError struct {
+	ID uint32
+}
+
+func (e *UnknownKeyError) Error() string {
+	return fmt.Sprintf("msglib: unknown frame key %d", e.ID)
+}
+
+// FrameOptions adds integrity checks to frames, sender and receiver need the same options.
+// A nil *FrameOptions leaves messages as they are.
+type FrameOptions struct {
+	Checksum bool     // append a CRC32C of the frame, against corruption on disk or the wire
+	Keys     *KeyRing // sign frames with HMAC-SHA256, against forged and altered messages
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// KeyRing holds HMAC-SHA256 keys by id. Frames are signed with the signing key
+// and verified with the key of their id, so keys rotate without breaking receivers:
+// Add the new key everywhere, SetSigningKey on senders, then Remove the old one
+// once frames signed with it are gone. A KeyRing is safe for concurrent use.
+// The zero KeyRing holds no keys, Add one and SetSigningKey before signing frames.
+type KeyRing struct {
+	lock    sync.RWMutex
+	keys    map[uint32][]byte
+	signing uint32
+	hasKey  bool
+}
+
+// NewKeyRing returns a KeyRing signing with key id
+func NewKeyRing(id uint32, key []byte) *KeyRing {
+	kr := &KeyRing{}
+	kr.Add(id, key)
+	kr.signing, kr.hasKey = id, true
+	return kr
+}
+
+// Add makes key id verify frames, replacing a key of the same id
+func (kr *KeyRing) Add(id uint32, key []byte) {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+	if kr.keys == nil {
+		kr.keys = make(map[uint32][]byte)
+	}
+	kr.keys[id] = append([]byte(nil), key...)
+}
+
+// Remove stops verifying frames of key id, the signing key can not be removed
+func (kr *KeyRing) Remove(id uint32) error {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+	if kr.hasKey && kr.signing == id {
+		return fmt.Errorf("msglib: frame key %d is the signing key", id)
+	}
+	delete(kr.keys, id)
+	return nil
+}
+
+// SetSigningKey signs following frames with key id, which was added before
+func (kr *KeyRing) SetSigningKey(id uint32) error {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+	if _, ok := kr.keys[id]; !ok {
+		return &UnknownKeyError{ID: id}
+	}
+	kr.signing, kr.hasKey = id, true
+	return nil
+}
+
+func (kr *KeyRing) signingKey() (uint32, []byte, error) {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+	if !kr.hasKey {
+		return 0, nil, ErrNoSigningKey
+	}
+	return kr.signing, kr.keys[kr.signing], nil
+}
+
+func (kr *KeyRing) key(id uint32) ([]byte, bool) {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+	key, ok := kr.keys[id]
+	return key, ok
+}
+
+// Seal appends the frame of msg to dst. It fails with ErrNoSigningKey,
+// returning dst unchanged, when Keys has no signing key.
+func (opts *FrameOptions) Seal(dst, msg []byte) ([]byte, error) {
+	if opts == nil {
+		return append(dst, msg...), nil
+	}
+	start := len(dst)
+	var id uint32
+	var key []byte
+	if opts.Keys != nil {
+		var err error
+		if id, key, err = opts.Keys.signingKey(); err != nil {
+			return dst, err
+		}
+		var header [binary.MaxVarintLen32]byte
+		dst = append(dst, header[:binary.PutUvarint(header[:], uint64(id))]...)
+	}
+	dst = append(dst, msg...)
+	if opts.Checksum {
+		var sum [crc32.Size]byte
+		binary.BigEndian.PutUint32(sum[:], crc32.Checksum(dst[start:], crc32cTable))
+		dst = append(dst, sum[:]...)
+	}
+	if opts.Keys != nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(dst[start:])
+		dst = mac.Sum(dst)
+	}
+	return dst, nil
+}
+
+// Open verifies a frame written by Seal and returns its message, which shares the frame.
+// The checksum is verified first, so corrupted frames fail with ErrChecksum
+// and altered or forged ones with ErrSignature or UnknownKeyError.
+func (opts *FrameOptions) Open(frame []byte) ([]byte, error) {
+	if opts == nil {
+		return frame, nil
+	}
+	body, sum := frame, []byte(nil)
+	if opts.Keys != nil {
+		if len(body) < sha256.Size {
+			return nil, ErrSignature
+		}
+		body, sum = body[:len(body)-sha256.Size], body[len(body)-sha256.Size:]
+	}
+	msg := body
+	if opts.Checksum {
+		if len(msg) < crc32.Size {
+			return nil, ErrChecksum
+		}
+		crc := binary.BigEndian.Uint32(msg[len(msg)-crc32.Size:])
+		if msg = msg[:len(msg)-crc32.Size]; crc32.Checksum(msg, crc32cTable) != crc {
+			return nil, ErrChecksum
+		}
+	}
+	if opts.Keys != nil {
+		id, n := binary.Uvarint(msg)
+		if n <= 0 || id > 1<<32-1 {
+			return nil, ErrSignature
+		}
+		key, ok := opts.Keys.key(uint32(id))
+		if !ok {
+			return nil, &UnknownKeyError{ID: uint32(id)}
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		if !hmac.Equal(mac.Sum(nil), sum) {
+			return nil, ErrSignature
+		}
+		msg = msg[n:]
+	}
+	return msg, nil
+}
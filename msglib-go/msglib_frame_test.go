@@ -0,0 +1,114 @@
+package msglib
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMsglibFrame(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: 2820, Tag: &msgTest1_Tag{Val: 242, Hash: []byte("hello")}}
+	keys := NewKeyRing(1, []byte("secret one"))
+	for _, opts := range []*FrameOptions{{Checksum: true}, {Keys: keys}, {Checksum: true, Keys: keys}} {
+		buff := &bytes.Buffer{}
+		enc := NewEncoder(buff)
+		enc.SetFrameOptions(opts)
+		if err := enc.Encode(obj); err != nil {
+			t.Fatalf("encode failure: %+v", err)
+		}
+		payload := buff.Bytes()
+		dec := NewDecoder(bytes.NewReader(payload))
+		dec.SetFrameOptions(opts)
+		var res msgTest1
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("decode failure: %+v", err)
+		}
+		if !reflect.DeepEqual(&res, obj) {
+			t.Fatalf("data err, expect %+v, got %+v", obj, &res)
+		}
+
+		// a flipped bit in the message is caught before decoding
+		corrupt := append([]byte(nil), payload...)
+		corrupt[2] ^= 0x10
+		dec = NewDecoder(bytes.NewReader(corrupt))
+		dec.SetFrameOptions(opts)
+		expect := ErrChecksum
+		if !opts.Checksum {
+			expect = ErrSignature
+		}
+		if err := dec.Decode(&res); err != expect {
+			t.Fatalf("expect %v, got %v", expect, err)
+		}
+	}
+
+	// keys rotate: old frames verify until their key is removed
+	opts := &FrameOptions{Keys: keys}
+	msg, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	old, err := opts.Seal(nil, msg)
+	if err != nil {
+		t.Fatalf("seal failure: %+v", err)
+	}
+	keys.Add(2, []byte("secret two"))
+	if err = keys.SetSigningKey(2); err != nil {
+		t.Fatalf("set signing key failure: %+v", err)
+	}
+	fresh, err := opts.Seal(nil, msg)
+	if err != nil {
+		t.Fatalf("seal failure: %+v", err)
+	}
+	for _, frame := range [][]byte{old, fresh} {
+		if res, err := opts.Open(frame); err != nil || !bytes.Equal(res, msg) {
+			t.Fatalf("data err, expect message, got %v", err)
+		}
+	}
+	if err = keys.Remove(2); err == nil {
+		t.Fatalf("expect failure removing the signing key")
+	}
+	if err = keys.Remove(1); err != nil {
+		t.Fatalf("remove key failure: %+v", err)
+	}
+	var uke *UnknownKeyError
+	if _, err = opts.Open(old); !errors.As(err, &uke) || uke.ID != 1 {
+		t.Fatalf("expect unknown key 1, got %v", err)
+	}
+
+	// the zero KeyRing takes keys like NewKeyRing, but signs nothing before SetSigningKey
+	var zero KeyRing
+	zero.Add(3, []byte("secret three"))
+	zeroOpts := &FrameOptions{Keys: &zero}
+	if _, err = zeroOpts.Seal(nil, msg); err != ErrNoSigningKey {
+		t.Fatalf("expect %v, got %v", ErrNoSigningKey, err)
+	}
+	enc := NewEncoder(&bytes.Buffer{})
+	enc.SetFrameOptions(zeroOpts)
+	if err = enc.Encode(obj); err != ErrNoSigningKey {
+		t.Fatalf("expect %v, got %v", ErrNoSigningKey, err)
+	}
+	if err = zero.SetSigningKey(4); !errors.As(err, &uke) || uke.ID != 4 {
+		t.Fatalf("expect unknown key 4, got %v", err)
+	}
+	if err = zero.SetSigningKey(3); err != nil {
+		t.Fatalf("set signing key failure: %+v", err)
+	}
+	sealed, err := zeroOpts.Seal(nil, msg)
+	if err != nil {
+		t.Fatalf("seal failure: %+v", err)
+	}
+	if res, err := zeroOpts.Open(sealed); err != nil || !bytes.Equal(res, msg) {
+		t.Fatalf("data err, expect message, got %v", err)
+	}
+
+	// frames signed with another key of the same id are forged
+	other := &FrameOptions{Keys: NewKeyRing(2, []byte("guessed"))}
+	forged, err := other.Seal(nil, msg)
+	if err != nil {
+		t.Fatalf("seal failure: %+v", err)
+	}
+	if _, err = opts.Open(forged); err != ErrSignature {
+		t.Fatalf("expect %v, got %v", ErrSignature, err)
+	}
+}
@@ -0,0 +1,58 @@
+//go:build go1.18
+
+package msglib
+
+import (
+	"reflect"
+)
+
+// Set is a set field, encoded as MT_SET like any map[T]struct{}.
+// A nil Set is empty but Add panics on it, create sets with NewSet or make.
+type Set[T comparable] map[T]struct{}
+
+// NewSet returns a set holding elems
+func NewSet[T comparable](elems ...T) Set[T] {
+	s := make(Set[T], len(elems))
+	for _, e := range elems {
+		s[e] = struct{}{}
+	}
+	return s
+}
+
+// Add puts e into the set
+func (s Set[T]) Add(e T) {
+	s[e] = struct{}{}
+}
+
+// Has reports whether e is in the set
+func (s Set[T]) Has(e T) bool {
+	_, ok := s[e]
+	return ok
+}
+
+// Remove deletes e from the set
+func (s Set[T]) Remove(e T) {
+	delete(s, e)
+}
+
+// Len returns the number of elements
+func (s Set[T]) Len() int {
+	return len(s)
+}
+
+// Marshal is Serialize of a struct or a pointer to a struct
+func Marshal[T any](v T) ([]byte, error) {
+	return Serialize(v)
+}
+
+// Unmarshal decodes payload into a new T, a struct or a pointer to a struct, like Deserialize
+func Unmarshal[T any](payload []byte) (T, error) {
+	var v T
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem()).Interface().(T)
+		err := Deserialize(payload, v)
+		return v, err
+	}
+	err := Deserialize(payload, &v)
+	return v, err
+}
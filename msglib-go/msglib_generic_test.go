@@ -0,0 +1,69 @@
+//go:build go1.18
+
+package msglib
+
+import (
+	"reflect"
+	"testing"
+)
+
+type msgTestSet struct {
+	Names Set[string] `msglib:"1"`
+	IDs   Set[int32]  `msglib:"2"`
+}
+
+type msgTestBoolSet struct {
+	Names map[string]bool `msglib:"1,set"`
+}
+
+func TestMsglibGeneric(t *testing.T) {
+	obj := msgTestSet{Names: NewSet("a", "b"), IDs: NewSet[int32]()}
+	obj.IDs.Add(3)
+	obj.IDs.Add(4)
+	obj.IDs.Remove(4)
+	if !obj.IDs.Has(3) || obj.IDs.Has(4) || obj.IDs.Len() != 1 {
+		t.Fatalf("data err, expect set of 3, got %v", obj.IDs)
+	}
+	if fieldType(reflect.TypeOf(obj.Names)) != MT_SET {
+		t.Fatalf("data err, expect %v, got %v", MT_SET, fieldType(reflect.TypeOf(obj.Names)))
+	}
+
+	payload, err := Marshal(obj)
+	if err != nil {
+		t.Fatalf("marshal object failure: %+v", err)
+	}
+	res, err := Unmarshal[msgTestSet](payload)
+	if err != nil {
+		t.Fatalf("unmarshal object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(res, obj) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, res)
+	}
+	ptr, err := Unmarshal[*msgTestSet](payload)
+	if err != nil {
+		t.Fatalf("unmarshal object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(*ptr, obj) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, *ptr)
+	}
+
+	// map[T]bool sets write their keys, false entries are left out
+	payload, err = Marshal(&msgTestBoolSet{Names: map[string]bool{"a": true, "b": false}})
+	if err != nil {
+		t.Fatalf("marshal object failure: %+v", err)
+	}
+	res, err = Unmarshal[msgTestSet](payload)
+	if err != nil {
+		t.Fatalf("unmarshal object failure: %+v", err)
+	}
+	if expect := NewSet("a"); !reflect.DeepEqual(res.Names, expect) {
+		t.Fatalf("data err, expect %v, got %v", expect, res.Names)
+	}
+	back, err := Unmarshal[msgTestBoolSet](payload)
+	if err != nil {
+		t.Fatalf("unmarshal object failure: %+v", err)
+	}
+	if expect := map[string]bool{"a": true}; !reflect.DeepEqual(back.Names, expect) {
+		t.Fatalf("data err, expect %v, got %v", expect, back.Names)
+	}
+}
@@ -0,0 +1,710 @@
+package msglib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// JSONOptions controls ToJSON and FromJSON, a nil *JSONOptions uses defaults.
+//
+// JSON objects use go field names of msglib tagged structs, or field ids when
+// no type is given, and hold the fields EncodeStruct would write. Binaries are base64 strings, sets are arrays, maps with
+// string, integer or bool keys are objects and other maps arrays of [key, value]
+// pairs. NaN and infinite floats are the strings "NaN", "Infinity" and "-Infinity".
+// Typer types are written like values of their wire type, which must not be a struct
+// or collection, and maps with Typer keys are arrays of pairs.
+type JSONOptions struct {
+	Int64AsString bool // write MT_I64 values as strings, javascript numbers lose precision above 2^53
+}
+
+// ToJSON converts a msglib payload into JSON. The payload is decoded into typ,
+// a pointer to a tagged struct, or read as Value with field ids as keys when typ is nil.
+func ToJSON(payload []byte, typ interface{}) ([]byte, error) {
+	return (*JSONOptions)(nil).ToJSON(payload, typ)
+}
+
+// FromJSON converts JSON written by ToJSON back into a msglib payload, decoding it into typ first.
+// Fields absent from the JSON get their defaults, fields may be named by go name or id.
+func FromJSON(data []byte, typ interface{}) ([]byte, error) {
+	return (*JSONOptions)(nil).FromJSON(data, typ)
+}
+
+// ToJSON is ToJSON with options of opts
+func (opts *JSONOptions) ToJSON(payload []byte, typ interface{}) (res []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if _, isRuntime := r.(runtime.Error); !ok || isRuntime {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	enc := &jsonEncoder{opts: opts}
+	if typ == nil {
+		if isEnvelope(payload) {
+			if payload, err = (*DecodeOptions)(nil).openEnvelope(payload); err != nil {
+				return nil, err
+			}
+		}
+		val, err := DecodeValue(newSliceReader(payload), NewBinaryProto())
+		if err != nil {
+			return nil, err
+		}
+		enc.writeAny(val)
+		return enc.buf.Bytes(), nil
+	}
+	if err = Deserialize(payload, typ); err != nil {
+		return nil, err
+	}
+	vo := reflect.ValueOf(typ)
+	for vo.Kind() == reflect.Ptr || vo.Kind() == reflect.Interface {
+		vo = vo.Elem()
+	}
+	enc.writeValue(vo, MT_STRUCT)
+	return enc.buf.Bytes(), nil
+}
+
+// FromJSON is FromJSON with options of opts
+func (opts *JSONOptions) FromJSON(data []byte, typ interface{}) (res []byte, err error) {
+	vo := reflect.ValueOf(typ)
+	if typ == nil || vo.Kind() != reflect.Ptr || vo.Elem().Kind() != reflect.Struct {
+		return nil, &UnsupportedValueError{Value: vo, Message: "expect pointer to struct"}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj interface{}
+	if err = dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if _, isRuntime := r.(runtime.Error); !ok || isRuntime {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	jd := &jsonDecoder{opts: opts}
+	jd.readValue(vo.Elem(), MT_STRUCT, obj)
+	return Serialize(typ)
+}
+
+func (opts *JSONOptions) int64AsString() bool {
+	return opts != nil && opts.Int64AsString
+}
+
+// encode
+
+type jsonEncoder struct {
+	buf  bytes.Buffer
+	opts *JSONOptions
+}
+
+func (enc *jsonEncoder) error(err error) {
+	panic(err)
+}
+
+func (enc *jsonEncoder) writeString(s string) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		enc.error(err)
+	}
+	enc.buf.Write(b)
+}
+
+func (enc *jsonEncoder) writeInt(n int64, msgtype byte) {
+	if msgtype == MT_I64 && enc.opts.int64AsString() {
+		enc.writeString(strconv.FormatInt(n, 10))
+		return
+	}
+	enc.buf.WriteString(strconv.FormatInt(n, 10))
+}
+
+func (enc *jsonEncoder) writeUint(n uint64, msgtype byte) {
+	if msgtype == MT_I64 && enc.opts.int64AsString() {
+		enc.writeString(strconv.FormatUint(n, 10))
+		return
+	}
+	enc.buf.WriteString(strconv.FormatUint(n, 10))
+}
+
+func (enc *jsonEncoder) writeFloat(f float64, bits int) {
+	switch {
+	case math.IsNaN(f):
+		enc.buf.WriteString(`"NaN"`)
+	case math.IsInf(f, 1):
+		enc.buf.WriteString(`"Infinity"`)
+	case math.IsInf(f, -1):
+		enc.buf.WriteString(`"-Infinity"`)
+	default:
+		enc.buf.WriteString(strconv.FormatFloat(f, 'g', -1, bits))
+	}
+}
+
+func (enc *jsonEncoder) writeBytes(b []byte) {
+	enc.buf.WriteByte('"')
+	enc.buf.WriteString(base64.StdEncoding.EncodeToString(b))
+	enc.buf.WriteByte('"')
+}
+
+func (enc *jsonEncoder) separator(i int) {
+	if i > 0 {
+		enc.buf.WriteByte(',')
+	}
+}
+
+// isJSONKeyType reports map key types written as json object keys
+func isJSONKeyType(msgtype byte) bool {
+	switch msgtype {
+	case MT_BOOL, MT_BYTE, MT_I16, MT_I32, MT_I64, MT_STRING:
+		return true
+	}
+	return false
+}
+
+// writeValue writes val of wire type msgtype, the same way EncodeStruct would
+func (enc *jsonEncoder) writeValue(val reflect.Value, msgtype byte) {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			enc.buf.WriteString("null")
+			return
+		}
+		val = val.Elem()
+	}
+	if isTyper(val.Type()) {
+		enc.writeMarshaler(val, msgtype)
+		return
+	}
+
+	switch msgtype {
+	case MT_BOOL:
+		enc.buf.WriteString(strconv.FormatBool(val.Bool()))
+	case MT_BYTE, MT_I16, MT_I32, MT_I64:
+		if isUintKind(val.Kind()) {
+			enc.writeUint(val.Uint(), msgtype)
+		} else {
+			enc.writeInt(val.Int(), msgtype)
+		}
+	case MT_FLOAT:
+		enc.writeFloat(val.Float(), 32)
+	case MT_DOUBLE:
+		enc.writeFloat(val.Float(), 64)
+	case MT_BINARY:
+		enc.writeBytes(bytesOf(val))
+	case MT_STRING:
+		if val.Kind() == reflect.Slice {
+			enc.writeBytes(val.Bytes())
+		} else {
+			enc.writeString(val.String())
+		}
+	case MT_STRUCT:
+		enc.buf.WriteByte('{')
+		n := 0
+		for _, ef := range encodeFields(val.Type()).sorted {
+			fieldValue, ok := fieldByIndex(val, ef.Index)
+			if !ok || isNilValue(fieldValue) || ef.omit(fieldValue) {
+				continue
+			}
+			enc.separator(n)
+			n++
+			enc.writeString(ef.Name)
+			enc.buf.WriteByte(':')
+			enc.writeValue(fieldValue, ef.Type)
+		}
+		enc.buf.WriteByte('}')
+	case MT_LIST:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			enc.writeBytes(bytesOf(val))
+			return
+		}
+		elemtype := fieldType(val.Type().Elem())
+		enc.buf.WriteByte('[')
+		for i := 0; i < val.Len(); i++ {
+			enc.separator(i)
+			enc.writeValue(val.Index(i), elemtype)
+		}
+		enc.buf.WriteByte(']')
+	case MT_SET:
+		if val.Kind() == reflect.Slice {
+			enc.writeValue(val, MT_LIST)
+			return
+		}
+		keytype := fieldType(val.Type().Key())
+		isBool := val.Type().Elem().Kind() == reflect.Bool
+		enc.buf.WriteByte('[')
+		n := 0
+		for _, k := range sortedKeys(val) {
+			if isBool && !val.MapIndex(k).Bool() {
+				continue
+			}
+			enc.separator(n)
+			n++
+			enc.writeValue(k, keytype)
+		}
+		enc.buf.WriteByte(']')
+	case MT_MAP:
+		keytype := fieldType(val.Type().Key())
+		valtype := fieldType(val.Type().Elem())
+		keys := sortedKeys(val)
+		if !isJSONKeyType(keytype) || isTyper(val.Type().Key()) {
+			enc.buf.WriteByte('[')
+			for i, k := range keys {
+				enc.separator(i)
+				enc.buf.WriteByte('[')
+				enc.writeValue(k, keytype)
+				enc.buf.WriteByte(',')
+				enc.writeValue(val.MapIndex(k), valtype)
+				enc.buf.WriteByte(']')
+			}
+			enc.buf.WriteByte(']')
+			return
+		}
+		enc.buf.WriteByte('{')
+		for i, k := range keys {
+			enc.separator(i)
+			enc.writeKey(k, keytype)
+			enc.buf.WriteByte(':')
+			enc.writeValue(val.MapIndex(k), valtype)
+		}
+		enc.buf.WriteByte('}')
+	default:
+		enc.error(&UnsupportedTypeError{Type: val.Type()})
+	}
+}
+
+// writeKey writes a map key as json object key
+func (enc *jsonEncoder) writeKey(k reflect.Value, keytype byte) {
+	for k.Kind() == reflect.Ptr || k.Kind() == reflect.Interface {
+		k = k.Elem()
+	}
+	switch {
+	case keytype == MT_STRING:
+		enc.writeString(k.String())
+	case keytype == MT_BOOL:
+		enc.writeString(strconv.FormatBool(k.Bool()))
+	case isUintKind(k.Kind()):
+		enc.writeString(strconv.FormatUint(k.Uint(), 10))
+	default:
+		enc.writeString(strconv.FormatInt(k.Int(), 10))
+	}
+}
+
+// jsonScalarTypes are go types holding the json of Typer values, by wire type.
+// Typers of other wire types have no json form, the field types are unknown.
+var jsonScalarTypes = map[byte]reflect.Type{
+	MT_BOOL:   reflect.TypeOf(false),
+	MT_BYTE:   reflect.TypeOf(uint8(0)),
+	MT_I16:    reflect.TypeOf(int16(0)),
+	MT_I32:    reflect.TypeOf(int32(0)),
+	MT_I64:    reflect.TypeOf(int64(0)),
+	MT_FLOAT:  reflect.TypeOf(float32(0)),
+	MT_DOUBLE: reflect.TypeOf(float64(0)),
+	MT_BINARY: reflect.TypeOf([]byte(nil)),
+	MT_STRING: reflect.TypeOf(""),
+}
+
+func isTyper(t reflect.Type) bool {
+	return t.Implements(typerType) || reflect.PtrTo(t).Implements(typerType)
+}
+
+// writeMarshaler writes types with a custom wire type through their msglib encoding
+func (enc *jsonEncoder) writeMarshaler(val reflect.Value, msgtype byte) {
+	m, ok := marshalerOf(val)
+	if _, scalar := jsonScalarTypes[msgtype]; !ok || !scalar {
+		enc.error(&UnsupportedTypeError{Type: val.Type()})
+	}
+	buf := &bytes.Buffer{}
+	proto := NewBinaryProto()
+	if err := m.MarshalMsglib(buf, proto); err != nil {
+		enc.error(err)
+	}
+	v, err := readAnyValue(buf, proto, msgtype, nil, 0)
+	if err != nil {
+		enc.error(err)
+	}
+	enc.writeAny(v)
+}
+
+func sortedKeys(val reflect.Value) []reflect.Value {
+	keys := val.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return compareValues(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// writeAny writes a Value, structs are objects keyed by field id
+func (enc *jsonEncoder) writeAny(v Value) {
+	switch v.Type {
+	case MT_BOOL:
+		enc.buf.WriteString(strconv.FormatBool(v.Bool))
+	case MT_BYTE:
+		// bytes are unsigned, the same as the typed path writes a byte field
+		enc.writeUint(uint64(uint8(v.Int)), v.Type)
+	case MT_I16, MT_I32, MT_I64:
+		enc.writeInt(v.Int, v.Type)
+	case MT_FLOAT:
+		enc.writeFloat(v.Float, 32)
+	case MT_DOUBLE:
+		enc.writeFloat(v.Float, 64)
+	case MT_BINARY:
+		enc.writeBytes(v.Bytes)
+	case MT_STRING:
+		enc.writeString(v.Str)
+	case MT_STRUCT:
+		enc.buf.WriteByte('{')
+		for i, f := range v.Fields {
+			enc.separator(i)
+			enc.writeString(strconv.Itoa(f.ID))
+			enc.buf.WriteByte(':')
+			enc.writeAny(f.Value)
+		}
+		enc.buf.WriteByte('}')
+	case MT_LIST, MT_SET:
+		enc.buf.WriteByte('[')
+		for i, e := range v.Elems {
+			enc.separator(i)
+			enc.writeAny(e)
+		}
+		enc.buf.WriteByte(']')
+	case MT_MAP:
+		if !isJSONKeyType(v.ElemType) {
+			enc.buf.WriteByte('[')
+			for i, e := range v.Entries {
+				enc.separator(i)
+				enc.buf.WriteByte('[')
+				enc.writeAny(e.Key)
+				enc.buf.WriteByte(',')
+				enc.writeAny(e.Value)
+				enc.buf.WriteByte(']')
+			}
+			enc.buf.WriteByte(']')
+			return
+		}
+		enc.buf.WriteByte('{')
+		for i, e := range v.Entries {
+			enc.separator(i)
+			switch e.Key.Type {
+			case MT_STRING:
+				enc.writeString(e.Key.Str)
+			case MT_BOOL:
+				enc.writeString(strconv.FormatBool(e.Key.Bool))
+			case MT_BYTE:
+				enc.writeString(strconv.FormatUint(uint64(uint8(e.Key.Int)), 10))
+			default:
+				enc.writeString(strconv.FormatInt(e.Key.Int, 10))
+			}
+			enc.buf.WriteByte(':')
+			enc.writeAny(e.Value)
+		}
+		enc.buf.WriteByte('}')
+	default:
+		enc.error(&UnsupportedValueError{Message: "unknown type " + strconv.Itoa(int(v.Type))})
+	}
+}
+
+// decode
+
+type jsonDecoder struct {
+	opts *JSONOptions
+}
+
+func (dec *jsonDecoder) error(err error) {
+	panic(err)
+}
+
+func (dec *jsonDecoder) mismatch(val reflect.Value, data interface{}) {
+	dec.error(&UnsupportedValueError{Value: val, Message: "unexpected json value " + strconv.Quote(jsonTypeName(data))})
+}
+
+func jsonTypeName(data interface{}) string {
+	switch data.(type) {
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+// readValue stores json data into val of wire type msgtype
+func (dec *jsonDecoder) readValue(val reflect.Value, msgtype byte, data interface{}) {
+	if data == nil {
+		val.Set(reflect.Zero(val.Type()))
+		return
+	}
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+	if val.Kind() == reflect.Interface {
+		dec.error(&UnsupportedTypeError{Type: val.Type()})
+	}
+	if isTyper(val.Type()) {
+		dec.readUnmarshaler(val, msgtype, data)
+		return
+	}
+
+	switch msgtype {
+	case MT_BOOL:
+		b, ok := data.(bool)
+		if !ok {
+			dec.mismatch(val, data)
+		}
+		val.SetBool(b)
+	case MT_BYTE, MT_I16, MT_I32, MT_I64:
+		dec.readInt(val, data)
+	case MT_FLOAT, MT_DOUBLE:
+		dec.readFloat(val, data)
+	case MT_BINARY:
+		s, ok := data.(string)
+		if !ok {
+			dec.mismatch(val, data)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			dec.error(err)
+		}
+		if val.Kind() == reflect.Array {
+			if len(b) != val.Len() {
+				dec.error(&UnsupportedValueError{Value: val, Message: "array length mismatch"})
+			}
+			reflect.Copy(val, reflect.ValueOf(b))
+		} else {
+			val.SetBytes(b)
+		}
+	case MT_STRING:
+		if val.Kind() == reflect.Slice {
+			dec.readValue(val, MT_BINARY, data)
+			return
+		}
+		s, ok := data.(string)
+		if !ok {
+			dec.mismatch(val, data)
+		}
+		val.SetString(s)
+	case MT_STRUCT:
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			dec.mismatch(val, data)
+		}
+		meta := encodeFields(val.Type())
+		for _, ef := range meta.defaults {
+			field, err := fieldByIndexAlloc(val, ef.Index)
+			if err != nil {
+				dec.error(err)
+			}
+			field.Set(ef.defaultValue)
+		}
+		for _, ef := range meta.sorted {
+			fieldData, ok := obj[ef.Name]
+			if !ok {
+				if fieldData, ok = obj[strconv.Itoa(ef.ID)]; !ok {
+					continue
+				}
+			}
+			field, err := fieldByIndexAlloc(val, ef.Index)
+			if err != nil {
+				dec.error(err)
+			}
+			dec.readValue(field, ef.Type, fieldData)
+		}
+	case MT_LIST, MT_SET:
+		if val.Kind() == reflect.Map {
+			dec.readSet(val, data)
+			return
+		}
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			dec.readValue(val, MT_BINARY, data)
+			return
+		}
+		arr, ok := data.([]interface{})
+		if !ok {
+			dec.mismatch(val, data)
+		}
+		elemtype := fieldType(val.Type().Elem())
+		if val.Kind() == reflect.Array {
+			if len(arr) != val.Len() {
+				dec.error(&UnsupportedValueError{Value: val, Message: "array length mismatch"})
+			}
+		} else {
+			val.Set(reflect.MakeSlice(val.Type(), len(arr), len(arr)))
+		}
+		for i, e := range arr {
+			dec.readValue(val.Index(i), elemtype, e)
+		}
+	case MT_MAP:
+		dec.readMap(val, data)
+	default:
+		dec.error(&UnsupportedTypeError{Type: val.Type()})
+	}
+}
+
+// readUnmarshaler reads types with a custom wire type through their msglib encoding,
+// the reverse of writeMarshaler
+func (dec *jsonDecoder) readUnmarshaler(val reflect.Value, msgtype byte, data interface{}) {
+	u, ok := unmarshalerOf(val)
+	st, scalar := jsonScalarTypes[msgtype]
+	if !ok || !scalar {
+		dec.error(&UnsupportedTypeError{Type: val.Type()})
+	}
+	tmp := reflect.New(st).Elem()
+	dec.readValue(tmp, msgtype, data)
+	v := Value{Type: msgtype}
+	switch msgtype {
+	case MT_BOOL:
+		v.Bool = tmp.Bool()
+	case MT_BYTE:
+		v.Int = int64(int8(tmp.Uint()))
+	case MT_I16, MT_I32, MT_I64:
+		v.Int = tmp.Int()
+	case MT_FLOAT, MT_DOUBLE:
+		v.Float = tmp.Float()
+	case MT_BINARY:
+		v.Bytes = tmp.Bytes()
+	case MT_STRING:
+		v.Str = tmp.String()
+	}
+	buf := &bytes.Buffer{}
+	proto := NewBinaryProto()
+	if err := EncodeValue(buf, proto, v); err != nil {
+		dec.error(err)
+	}
+	if err := u.UnmarshalMsglib(buf, proto); err != nil {
+		dec.error(err)
+	}
+}
+
+func (dec *jsonDecoder) readInt(val reflect.Value, data interface{}) {
+	var s string
+	switch d := data.(type) {
+	case json.Number:
+		s = d.String()
+	case string:
+		s = d
+	default:
+		dec.mismatch(val, data)
+	}
+	if isUintKind(val.Kind()) {
+		n, err := strconv.ParseUint(s, 10, val.Type().Bits())
+		if err != nil {
+			dec.intError(val, s, err)
+		}
+		val.SetUint(n)
+	} else {
+		n, err := strconv.ParseInt(s, 10, val.Type().Bits())
+		if err != nil {
+			dec.intError(val, s, err)
+		}
+		val.SetInt(n)
+	}
+}
+
+// intError reports an integer out of the range of val as OverflowError,
+// any other malformed integer as UnsupportedValueError
+func (dec *jsonDecoder) intError(val reflect.Value, s string, err error) {
+	if errors.Is(err, strconv.ErrRange) {
+		dec.error(&OverflowError{Value: s, Type: val.Type().String()})
+	}
+	dec.error(&UnsupportedValueError{Value: val, Message: "invalid integer " + strconv.Quote(s)})
+}
+
+func (dec *jsonDecoder) readFloat(val reflect.Value, data interface{}) {
+	var f float64
+	var err error
+	switch d := data.(type) {
+	case json.Number:
+		f, err = strconv.ParseFloat(d.String(), val.Type().Bits())
+	case string:
+		switch d {
+		case "NaN":
+			f = math.NaN()
+		case "Infinity":
+			f = math.Inf(1)
+		case "-Infinity":
+			f = math.Inf(-1)
+		default:
+			f, err = strconv.ParseFloat(d, val.Type().Bits())
+		}
+	default:
+		dec.mismatch(val, data)
+	}
+	if err != nil {
+		dec.error(err)
+	}
+	val.SetFloat(f)
+}
+
+func (dec *jsonDecoder) readSet(val reflect.Value, data interface{}) {
+	arr, ok := data.([]interface{})
+	if !ok {
+		dec.mismatch(val, data)
+	}
+	keytype := fieldType(val.Type().Key())
+	val.Set(reflect.MakeMapWithSize(val.Type(), len(arr)))
+	for _, e := range arr {
+		key := reflect.New(val.Type().Key()).Elem()
+		dec.readValue(key, keytype, e)
+		if val.Type().Elem().Kind() == reflect.Bool {
+			val.SetMapIndex(key, reflect.ValueOf(true).Convert(val.Type().Elem()))
+		} else {
+			val.SetMapIndex(key, reflect.Zero(val.Type().Elem()))
+		}
+	}
+}
+
+func (dec *jsonDecoder) readMap(val reflect.Value, data interface{}) {
+	keytype := fieldType(val.Type().Key())
+	valtype := fieldType(val.Type().Elem())
+	val.Set(reflect.MakeMap(val.Type()))
+	switch d := data.(type) {
+	case map[string]interface{}:
+		if !isJSONKeyType(keytype) || isTyper(val.Type().Key()) {
+			dec.mismatch(val, data)
+		}
+		for k, v := range d {
+			key := reflect.New(val.Type().Key()).Elem()
+			var keyData interface{} = k
+			if keytype == MT_BOOL {
+				b, err := strconv.ParseBool(k)
+				if err != nil {
+					dec.error(err)
+				}
+				keyData = b
+			}
+			dec.readValue(key, keytype, keyData)
+			elem := reflect.New(val.Type().Elem()).Elem()
+			dec.readValue(elem, valtype, v)
+			val.SetMapIndex(key, elem)
+		}
+	case []interface{}:
+		for _, e := range d {
+			pair, ok := e.([]interface{})
+			if !ok || len(pair) != 2 {
+				dec.error(&UnsupportedValueError{Value: val, Message: "expect [key, value] pairs"})
+			}
+			key := reflect.New(val.Type().Key()).Elem()
+			dec.readValue(key, keytype, pair[0])
+			elem := reflect.New(val.Type().Elem()).Elem()
+			dec.readValue(elem, valtype, pair[1])
+			val.SetMapIndex(key, elem)
+		}
+	default:
+		dec.mismatch(val, data)
+	}
+}
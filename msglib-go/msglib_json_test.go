@@ -0,0 +1,170 @@
+package msglib
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMsglibJSON(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: -3}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte{0xab}}
+	obj.TagList = []*msgTest1_Tag{{Val: 1}}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+
+	data, err := ToJSON(payload, &msgTest1{})
+	if err != nil {
+		t.Fatalf("to json failure: %+v", err)
+	}
+	expect := `{"Name":"xixi","Age":-3,"Tag":{"Hash":"qw==","Val":242},"TagList":[{"Val":1}]}`
+	if string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v", expect, string(data))
+	}
+	res, err := FromJSON(data, &msgTest1{})
+	if err != nil {
+		t.Fatalf("from json failure: %+v", err)
+	}
+	if !bytes.Equal(res, payload) {
+		t.Fatalf("data err, expect %v, got %v", payload, res)
+	}
+
+	// field ids without a type
+	data, err = ToJSON(payload, nil)
+	if err != nil {
+		t.Fatalf("to json failure: %+v", err)
+	}
+	expect = `{"1":"xixi","2":-3,"3":{"1":"qw==","2":242},"4":[{"2":1}]}`
+	if string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v", expect, string(data))
+	}
+	res, err = FromJSON(data, &msgTest1{})
+	if err != nil || !bytes.Equal(res, payload) {
+		t.Fatalf("data err, expect %v, got %v, err = %v", payload, res, err)
+	}
+
+	// maps, sets and int64 as strings
+	maps := &msgTestMaps{
+		Tail:    1,
+		Names:   map[string]int32{"b": 2, "a": 1},
+		Ids:     map[int64]string{1 << 60: "big"},
+		Hashes:  map[[2]byte]bool{{1, 2}: true},
+		Keys:    map[msgTestKey]string{{Shard: 1, Name: "x"}: "y"},
+		Members: map[uint16]struct{}{9: {}, 7: {}},
+	}
+	payload, err = (&EncodeOptions{Deterministic: true}).Serialize(maps)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	opts := &JSONOptions{Int64AsString: true}
+	data, err = opts.ToJSON(payload, &msgTestMaps{})
+	if err != nil {
+		t.Fatalf("to json failure: %+v", err)
+	}
+	expect = `{"Names":{"a":1,"b":2},"Ids":{"1152921504606846976":"big"},"Hashes":[["AQI=",true]],` +
+		`"Keys":[[{"Shard":1,"Name":"x"},"y"]],"Members":[7,9],"Tail":1}`
+	if string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v", expect, string(data))
+	}
+	res, err = (&EncodeOptions{Deterministic: true}).Serialize(mustFromJSON(t, opts, data))
+	if err != nil || !bytes.Equal(res, payload) {
+		t.Fatalf("data err, expect %v, got %v, err = %v", payload, res, err)
+	}
+
+	// int64 values as strings
+	data, err = opts.ToJSON(mustSerialize(t, &msgTestInt{Count: -1 << 62, Size: 3, Legacy: 4}), &msgTestInt{})
+	expect = `{"Count":"-4611686018427387904","Size":"3","Legacy":4}`
+	if err != nil || string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v, err = %v", expect, string(data), err)
+	}
+
+	// bytes are unsigned with and without a type
+	payload = mustSerialize(t, &msgTestNoDefault{Level: &[]uint8{200}[0]})
+	data, err = ToJSON(payload, &msgTestNoDefault{})
+	if expect = `{"Level":200}`; err != nil || string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v, err = %v", expect, string(data), err)
+	}
+	data, err = ToJSON(payload, nil)
+	if expect = `{"5":200}`; err != nil || string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v, err = %v", expect, string(data), err)
+	}
+	res, err = FromJSON(data, &msgTestNoDefault{})
+	if err != nil || !bytes.Equal(res, payload) {
+		t.Fatalf("data err, expect %v, got %v, err = %v", payload, res, err)
+	}
+
+	// byte map keys are unsigned too
+	payload = mustSerialize(t, &msgTestByteKeys{Names: map[uint8]string{200: "x"}})
+	data, err = ToJSON(payload, &msgTestByteKeys{})
+	if expect = `{"Names":{"200":"x"}}`; err != nil || string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v, err = %v", expect, string(data), err)
+	}
+	data, err = ToJSON(payload, nil)
+	if expect = `{"1":{"200":"x"}}`; err != nil || string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v, err = %v", expect, string(data), err)
+	}
+	res, err = FromJSON(data, &msgTestByteKeys{})
+	if err != nil || !bytes.Equal(res, payload) {
+		t.Fatalf("data err, expect %v, got %v, err = %v", payload, res, err)
+	}
+
+	// Typers convert both ways
+	payload = mustSerialize(t, &msgTestMarshaler{
+		Created: msgTestStamp{Unix: 1500000000},
+		History: []msgTestStamp{{Unix: 1}},
+		Named:   map[string]*msgTestStamp{"a": {Unix: 3}},
+	})
+	data, err = ToJSON(payload, &msgTestMarshaler{})
+	if expect = `{"Created":"1500000000","History":["1"],"Named":{"a":"3"},"Replaced":"0"}`; err != nil || string(data) != expect {
+		t.Fatalf("data err, expect %v, got %v, err = %v", expect, string(data), err)
+	}
+	res, err = FromJSON(data, &msgTestMarshaler{})
+	if err != nil || !bytes.Equal(res, payload) {
+		t.Fatalf("data err, expect %v, got %v, err = %v", payload, res, err)
+	}
+	if _, err = FromJSON([]byte(`{"Created":"x"}`), &msgTestMarshaler{}); err == nil {
+		t.Fatalf("expect invalid stamp")
+	}
+
+	// errors
+	var uve *UnsupportedValueError
+	if _, err = FromJSON([]byte(`{"Legacy":"x"}`), &msgTestInt{}); !errors.As(err, &uve) {
+		t.Fatalf("expect invalid number, got %v", err)
+	}
+	var oe *OverflowError
+	if _, err = FromJSON([]byte(`{"Size":18446744073709551616}`), &msgTestInt{}); !errors.As(err, &oe) {
+		t.Fatalf("expect overflow, got %v", err)
+	}
+	if _, err = FromJSON([]byte(`{"Age":1}`), nil); err == nil {
+		t.Fatalf("expect missing type")
+	}
+	if _, err = FromJSON([]byte(`{"Name":1}`), &msgTest1{}); err == nil {
+		t.Fatalf("expect type mismatch")
+	}
+}
+
+type msgTestByteKeys struct {
+	Names map[uint8]string `msglib:"1"`
+}
+
+func mustSerialize(t *testing.T, data interface{}) []byte {
+	payload, err := Serialize(data)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	return payload
+}
+
+func mustFromJSON(t *testing.T, opts *JSONOptions, data []byte) *msgTestMaps {
+	payload, err := opts.FromJSON(data, &msgTestMaps{})
+	if err != nil {
+		t.Fatalf("from json failure: %+v", err)
+	}
+	obj := &msgTestMaps{}
+	if err = Deserialize(payload, obj); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	return obj
+}
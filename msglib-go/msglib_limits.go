@@ -0,0 +1,163 @@
+package msglib
+
+import (
+	"fmt"
+	"io"
+)
+
+// DecodeOptions limits resources used when decoding untrusted payloads.
+// Zero values mean unlimited, a nil *DecodeOptions has no limits at all.
+// A Marshaler, e.g. code generated by msglibc, reading through the binary proto
+// is held to MaxBytes, MaxBinaryLength and MaxCollectionCount, but not to MaxDepth.
+type DecodeOptions struct {
+	MaxBytes           int64 // max bytes read from the reader for one message
+	MaxBinaryLength    int   // max length of a single binary or string value
+	MaxCollectionCount int   // max element count of a single list, set or map
+	MaxDepth           int   // max nesting depth of structs, lists, sets and maps
+
+	// AliasBytes makes []byte fields decoded by Deserialize point into the payload
+	// instead of holding copies. The payload must then stay unmodified for as long
+	// as the decoded value is used. Strings and fixed-size arrays are always copied.
+	AliasBytes bool
+
+	// Strict fails on fields and elements whose wire type differs from the go type,
+	// instead of widening compatible types like MT_I32 into an int64 field.
+	Strict bool
+
+	// MaxDecompressedBytes limits the message in a compressed envelope. As a few kilobytes
+	// of deflate expand to gigabytes, zero means MaxBytes, or DefaultMaxDecompressedBytes
+	// without MaxBytes, negative unlimited.
+	MaxDecompressedBytes int64
+}
+
+// LimitError is returned when decoding exceeds one of the DecodeOptions limits
+type LimitError struct {
+	Limit string // name of the DecodeOptions field
+	Max   int64
+	Value int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("msglib: %s exceeded, limit %d, got %d", e.Limit, e.Max, e.Value)
+}
+
+// DecodeStruct is DecodeStruct enforcing limits of opts
+func (opts *DecodeOptions) DecodeStruct(reader io.Reader, proto IMProto, val interface{}) error {
+	return decodeStruct(reader, proto, val, opts)
+}
+
+// SkipValue is SkipValue enforcing limits of opts
+func (opts *DecodeOptions) SkipValue(reader io.Reader, proto IMProto, msgtype byte) error {
+	reader = opts.limitReader(reader)
+	setProtoOptions(proto, opts)
+	defer setProtoOptions(proto, nil)
+	return skipValue(reader, proto, msgtype, opts, 0)
+}
+
+// Deserialize is Deserialize enforcing limits of opts
+func (opts *DecodeOptions) Deserialize(payload []byte, data interface{}) error {
+	if opts != nil && opts.MaxBytes > 0 && int64(len(payload)) > opts.MaxBytes {
+		return &LimitError{Limit: "MaxBytes", Max: opts.MaxBytes, Value: int64(len(payload))}
+	}
+	if isEnvelope(payload) && hasEnvelope(data) {
+		msg, err := opts.openEnvelope(payload)
+		if err != nil {
+			return err
+		}
+		payload = msg
+	}
+	return opts.DecodeStruct(newSliceReader(payload), NewBinaryProto(), data)
+}
+
+func (opts *DecodeOptions) aliasBytes() bool {
+	return opts != nil && opts.AliasBytes
+}
+
+func (opts *DecodeOptions) checkLength(n int) error {
+	if opts != nil && opts.MaxBinaryLength > 0 && n > opts.MaxBinaryLength {
+		return &LimitError{Limit: "MaxBinaryLength", Max: int64(opts.MaxBinaryLength), Value: int64(n)}
+	}
+	return nil
+}
+
+func (opts *DecodeOptions) checkCount(n int) error {
+	if opts != nil && opts.MaxCollectionCount > 0 && n > opts.MaxCollectionCount {
+		return &LimitError{Limit: "MaxCollectionCount", Max: int64(opts.MaxCollectionCount), Value: int64(n)}
+	}
+	return nil
+}
+
+func (opts *DecodeOptions) checkDepth(depth int) error {
+	if opts != nil && opts.MaxDepth > 0 && depth > opts.MaxDepth {
+		return &LimitError{Limit: "MaxDepth", Max: int64(opts.MaxDepth), Value: int64(depth)}
+	}
+	return nil
+}
+
+func (opts *DecodeOptions) limitReader(reader io.Reader) io.Reader {
+	if opts == nil || opts.MaxBytes <= 0 {
+		return reader
+	}
+	if sr, ok := reader.(*sliceReader); ok && int64(sr.Len()) <= opts.MaxBytes {
+		// keeps the slice fast path, the payload cannot exceed the limit
+		return reader
+	}
+	lr := &limitedReader{reader: reader, remain: opts.MaxBytes, max: opts.MaxBytes}
+	if br, ok := reader.(io.ByteReader); ok {
+		lr.byteReader = br
+	}
+	return lr
+}
+
+// protoLimiter is implemented by protos which check lengths before allocating buffers
+type protoLimiter interface {
+	setDecodeOptions(opts *DecodeOptions)
+}
+
+func setProtoOptions(proto IMProto, opts *DecodeOptions) {
+	if pl, ok := proto.(protoLimiter); ok {
+		pl.setDecodeOptions(opts)
+	}
+}
+
+// limitedReader fails with LimitError after reading max bytes, keeping io.ByteReader of the underlying reader
+type limitedReader struct {
+	reader     io.Reader
+	byteReader io.ByteReader
+	remain     int64
+	max        int64
+	oneByte    [1]byte
+}
+
+func (lr *limitedReader) exceeded() error {
+	return &LimitError{Limit: "MaxBytes", Max: lr.max, Value: lr.max + 1}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remain <= 0 {
+		return 0, lr.exceeded()
+	}
+	if int64(len(p)) > lr.remain {
+		p = p[:lr.remain]
+	}
+	n, err := lr.reader.Read(p)
+	lr.remain -= int64(n)
+	return n, err
+}
+
+func (lr *limitedReader) ReadByte() (byte, error) {
+	if lr.remain <= 0 {
+		return 0, lr.exceeded()
+	}
+	if lr.byteReader != nil {
+		c, err := lr.byteReader.ReadByte()
+		if err == nil {
+			lr.remain--
+		}
+		return c, err
+	}
+	if _, err := io.ReadFull(lr, lr.oneByte[:]); err != nil {
+		return 0, err
+	}
+	return lr.oneByte[0], nil
+}
@@ -0,0 +1,77 @@
+package msglib
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func isLimitError(err error, limit string) bool {
+	var le *LimitError
+	return errors.As(err, &le) && le.Limit == limit
+}
+
+func TestDecodeLimits(t *testing.T) {
+	obj := new(msgTest1)
+	obj.Name = "xixi"
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte("hello world")}
+	obj.TagList = []*msgTest1_Tag{{Val: 1}, {Val: 2}, {Val: 3}}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+
+	var obj2 msgTest1
+	if err = (&DecodeOptions{MaxBytes: int64(len(payload)), MaxBinaryLength: 11, MaxCollectionCount: 3, MaxDepth: 3}).Deserialize(payload, &obj2); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+
+	cases := map[string]*DecodeOptions{
+		"MaxBytes":           {MaxBytes: int64(len(payload) - 1)},
+		"MaxBinaryLength":    {MaxBinaryLength: 10},
+		"MaxCollectionCount": {MaxCollectionCount: 2},
+		"MaxDepth":           {MaxDepth: 2},
+	}
+	for limit, opts := range cases {
+		if err = opts.Deserialize(payload, &obj2); !isLimitError(err, limit) {
+			t.Fatalf("expect %s limit error, got %v", limit, err)
+		}
+		// limits apply to skipped values as well
+		if err = opts.SkipValue(bytes.NewBuffer(payload), NewBinaryProto(), MT_STRUCT); !isLimitError(err, limit) {
+			t.Fatalf("expect %s limit error when skipping, got %v", limit, err)
+		}
+	}
+}
+
+func TestDecodeLimitsProto(t *testing.T) {
+	// Marshalers read collections through the proto, which applies MaxCollectionCount
+	buff := &bytes.Buffer{}
+	proto := NewBinaryProto()
+	proto.WriteListBegin(buff, &MList{ElementType: MT_I32, Count: 3})
+	proto.WriteMapBegin(buff, &MMap{KeyType: MT_I32, ValueType: MT_I32, Count: 3})
+	setProtoOptions(proto, &DecodeOptions{MaxCollectionCount: 2})
+	if _, err := proto.ReadListBegin(buff); !isLimitError(err, "MaxCollectionCount") {
+		t.Fatalf("expect MaxCollectionCount limit error, got %v", err)
+	}
+	if _, err := proto.ReadMapBegin(buff); !isLimitError(err, "MaxCollectionCount") {
+		t.Fatalf("expect MaxCollectionCount limit error, got %v", err)
+	}
+}
+
+func TestDecodeForgedLength(t *testing.T) {
+	// field 1 string with a forged length and no data
+	buff := &bytes.Buffer{}
+	proto := NewBinaryProto()
+	proto.WriteFieldBegin(buff, &MField{Type: MT_STRING, ID: 1})
+	proto.WriteI64(buff, 1<<31)
+	payload := buff.Bytes()
+
+	var obj msgTest1
+	if err := Deserialize(payload, &obj); err == nil {
+		t.Fatalf("expect error for truncated payload")
+	}
+	opts := &DecodeOptions{MaxBinaryLength: 1024}
+	if err := opts.Deserialize(payload, &obj); !isLimitError(err, "MaxBinaryLength") {
+		t.Fatalf("expect MaxBinaryLength limit error, got %v", err)
+	}
+}
@@ -0,0 +1,895 @@
+package msglib
+
+import (
+	"io"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Plans are the compiled form of encodeFields: a closure per go type, built once,
+// walking values without looking up fields, tags or types again.
+// Plans return errors instead of panicking, a type which cannot be encoded
+// gets a plan returning the error.
+
+// encodeFunc writes val, a value of the go type the plan was compiled for
+type encodeFunc func(enc *encoder, val reflect.Value) error
+
+// decodeFunc reads a value of wire type wire into val, a settable value of the go type the plan was compiled for
+type decodeFunc func(dec *decoder, wire byte, val reflect.Value) error
+
+type encodePlan struct {
+	encode encodeFunc
+}
+
+type decodePlan struct {
+	decode decodeFunc
+}
+
+// encodePlanKey tells plans apart by wire type, tag options like i32 change it
+type encodePlanKey struct {
+	t       reflect.Type
+	msgtype byte
+}
+
+var (
+	planLock    sync.Mutex // compiles plans one at a time, next to encodeFieldsCache
+	encodePlans sync.Map   // encodePlanKey -> *encodePlan
+	decodePlans sync.Map   // reflect.Type -> *decodePlan
+)
+
+// planCompiler holds plans being compiled, published when compiling succeeds.
+// Recursive types find their own plan here before it is complete.
+type planCompiler struct {
+	encodes map[encodePlanKey]*encodePlan
+	decodes map[reflect.Type]*decodePlan
+}
+
+// encodePlanFor returns the plan writing values of go type t as msgtype
+func encodePlanFor(t reflect.Type, msgtype byte) (p *encodePlan) {
+	key := encodePlanKey{t, msgtype}
+	if cached, ok := encodePlans.Load(key); ok {
+		return cached.(*encodePlan)
+	}
+	planLock.Lock()
+	defer planLock.Unlock()
+	defer func() {
+		if err := compileError(recover()); err != nil {
+			p = &encodePlan{encode: func(enc *encoder, val reflect.Value) error { return err }}
+			encodePlans.Store(key, p)
+		}
+	}()
+	c := &planCompiler{encodes: make(map[encodePlanKey]*encodePlan), decodes: make(map[reflect.Type]*decodePlan)}
+	p = c.encodePlan(t, msgtype)
+	c.publish()
+	return p
+}
+
+// decodePlanFor returns the plan reading values into go type t
+func decodePlanFor(t reflect.Type) (p *decodePlan) {
+	if cached, ok := decodePlans.Load(t); ok {
+		return cached.(*decodePlan)
+	}
+	planLock.Lock()
+	defer planLock.Unlock()
+	defer func() {
+		if err := compileError(recover()); err != nil {
+			p = &decodePlan{decode: func(dec *decoder, wire byte, val reflect.Value) error { return err }}
+			decodePlans.Store(t, p)
+		}
+	}()
+	c := &planCompiler{encodes: make(map[encodePlanKey]*encodePlan), decodes: make(map[reflect.Type]*decodePlan)}
+	p = c.decodePlan(t)
+	c.publish()
+	return p
+}
+
+// compileError turns panics of encodeFields and fieldType back into errors
+func compileError(r interface{}) error {
+	if r == nil {
+		return nil
+	}
+	if _, ok := r.(runtime.Error); ok {
+		panic(r)
+	}
+	err, ok := r.(error)
+	if !ok {
+		panic(r)
+	}
+	return err
+}
+
+func (c *planCompiler) publish() {
+	for key, p := range c.encodes {
+		encodePlans.Store(key, p)
+	}
+	for t, p := range c.decodes {
+		decodePlans.Store(t, p)
+	}
+}
+
+func (c *planCompiler) encodePlan(t reflect.Type, msgtype byte) *encodePlan {
+	key := encodePlanKey{t, msgtype}
+	if cached, ok := encodePlans.Load(key); ok {
+		return cached.(*encodePlan)
+	}
+	if p, ok := c.encodes[key]; ok {
+		return p
+	}
+	p := &encodePlan{}
+	c.encodes[key] = p
+	p.encode = c.compileEncode(t, msgtype)
+	return p
+}
+
+func (c *planCompiler) decodePlan(t reflect.Type) *decodePlan {
+	if cached, ok := decodePlans.Load(t); ok {
+		return cached.(*decodePlan)
+	}
+	if p, ok := c.decodes[t]; ok {
+		return p
+	}
+	p := &decodePlan{}
+	c.decodes[t] = p
+	p.decode = c.compileDecode(t)
+	return p
+}
+
+// encode plans
+
+func encodeUnsupported(t reflect.Type) encodeFunc {
+	return func(enc *encoder, val reflect.Value) error {
+		return &UnsupportedTypeError{Type: t}
+	}
+}
+
+func (c *planCompiler) compileEncode(t reflect.Type, msgtype byte) encodeFunc {
+	// marshalers write themselves, before pointers are followed
+	if t.Kind() == reflect.Interface {
+		return func(enc *encoder, val reflect.Value) error {
+			if val.IsNil() {
+				return &UnsupportedValueError{Value: val, Message: "nil interface"}
+			}
+			elem := val.Elem()
+			return encodePlanFor(elem.Type(), msgtype).encode(enc, elem)
+		}
+	}
+	if t.Implements(marshalerType) {
+		return func(enc *encoder, val reflect.Value) error {
+			return val.Interface().(Marshaler).MarshalMsglib(enc.writer, enc.proto)
+		}
+	}
+	if reflect.PtrTo(t).Implements(marshalerType) {
+		return func(enc *encoder, val reflect.Value) error {
+			m, _ := marshalerOf(val)
+			return m.MarshalMsglib(enc.writer, enc.proto)
+		}
+	}
+	if t.Kind() == reflect.Ptr {
+		elem := c.encodePlan(t.Elem(), msgtype)
+		return func(enc *encoder, val reflect.Value) error {
+			if val.IsNil() {
+				return &UnsupportedValueError{Value: val, Message: "nil pointer"}
+			}
+			return elem.encode(enc, val.Elem())
+		}
+	}
+
+	kind := t.Kind()
+	isInt := kind >= reflect.Int && kind <= reflect.Int64 || isUintKind(kind)
+	switch msgtype {
+	case MT_BOOL:
+		if kind == reflect.Bool {
+			return func(enc *encoder, val reflect.Value) error {
+				return enc.proto.WriteBool(enc.writer, val.Bool())
+			}
+		}
+	case MT_BYTE:
+		if isUintKind(kind) {
+			return func(enc *encoder, val reflect.Value) error {
+				return enc.proto.WriteByte(enc.writer, byte(val.Uint()))
+			}
+		} else if isInt {
+			return func(enc *encoder, val reflect.Value) error {
+				return enc.proto.WriteByte(enc.writer, byte(val.Int()))
+			}
+		}
+	case MT_I16, MT_I32, MT_I64:
+		if isInt {
+			return func(enc *encoder, val reflect.Value) error {
+				return enc.writeInt(val, msgtype)
+			}
+		}
+	case MT_FLOAT:
+		if kind == reflect.Float32 || kind == reflect.Float64 {
+			return func(enc *encoder, val reflect.Value) error {
+				return enc.proto.WriteFloat32(enc.writer, float32(val.Float()))
+			}
+		}
+	case MT_DOUBLE:
+		if kind == reflect.Float32 || kind == reflect.Float64 {
+			return func(enc *encoder, val reflect.Value) error {
+				return enc.proto.WriteFloat64(enc.writer, val.Float())
+			}
+		}
+	case MT_BINARY:
+		if (kind == reflect.Slice || kind == reflect.Array) && t.Elem().Kind() == reflect.Uint8 {
+			return encodeBytes
+		}
+	case MT_STRING:
+		if kind == reflect.String {
+			return func(enc *encoder, val reflect.Value) error {
+				return enc.proto.WriteString(enc.writer, val.String())
+			}
+		}
+	case MT_STRUCT:
+		if kind == reflect.Struct {
+			return c.compileStructEncode(t)
+		}
+	case MT_MAP:
+		if kind == reflect.Map {
+			return c.compileMapEncode(t)
+		}
+	case MT_LIST:
+		if kind == reflect.Slice || kind == reflect.Array {
+			if t.Elem().Kind() == reflect.Uint8 {
+				return encodeBytes
+			}
+			return c.compileListEncode(t)
+		}
+	case MT_SET:
+		if kind == reflect.Slice || kind == reflect.Array {
+			return c.compileSetEncode(t)
+		} else if kind == reflect.Map {
+			return c.compileMapSetEncode(t)
+		}
+	}
+	return encodeUnsupported(t)
+}
+
+// encodeBytes writes a byte slice or a byte array as MT_BINARY
+func encodeBytes(enc *encoder, val reflect.Value) error {
+	if val.Kind() == reflect.Array && val.CanAddr() {
+		val = val.Slice(0, val.Len())
+	}
+	return enc.proto.WriteBinary(enc.writer, bytesOf(val))
+}
+
+type encodeFieldPlan struct {
+	encodeField
+	plan *encodePlan
+}
+
+func (c *planCompiler) compileStructEncode(t reflect.Type) encodeFunc {
+	meta := encodeFields(t)
+	fields := make([]encodeFieldPlan, len(meta.sorted))
+	for i, ef := range meta.sorted {
+		fields[i] = encodeFieldPlan{encodeField: ef, plan: c.encodePlan(ef.GoType, ef.Type)}
+	}
+	unknown := meta.unknown
+	return func(enc *encoder, val reflect.Value) error {
+		if err := enc.proto.WriteStructBegin(enc.writer, &enc.mstruct); err != nil {
+			return err
+		}
+		var missing *MissingFieldError
+		for i := range fields {
+			f := &fields[i]
+			fieldValue, ok := fieldByIndex(val, f.Index)
+			if !ok || isNilValue(fieldValue) {
+				if f.required >= 0 {
+					missing = addMissingField(missing, t, f.encodeField)
+				}
+				continue
+			}
+			if missing != nil || f.omit(fieldValue) {
+				continue
+			}
+			enc.mfield = MField{Name: f.Name, Type: f.Type, ID: f.ID}
+			if err := enc.proto.WriteFieldBegin(enc.writer, &enc.mfield); err != nil {
+				return err
+			}
+			if err := f.plan.encode(enc, fieldValue); err != nil {
+				return err
+			}
+		}
+		if missing != nil {
+			return missing
+		}
+		if unknown != nil {
+			if raw, ok := fieldByIndex(val, unknown); ok && raw.Len() > 0 {
+				if err := enc.writeUnknown(raw.Bytes()); err != nil {
+					return err
+				}
+			}
+		}
+		return enc.proto.WriteFieldStop(enc.writer)
+	}
+}
+
+func (c *planCompiler) compileMapEncode(t reflect.Type) encodeFunc {
+	keytype, valtype := fieldType(t.Key()), fieldType(t.Elem())
+	keyPlan, valPlan := c.encodePlan(t.Key(), keytype), c.encodePlan(t.Elem(), valtype)
+	return func(enc *encoder, val reflect.Value) error {
+		enc.mmap = MMap{KeyType: keytype, ValueType: valtype, Count: val.Len()}
+		if err := enc.proto.WriteMapBegin(enc.writer, &enc.mmap); err != nil {
+			return err
+		}
+		return enc.rangeMap(val, func(k, v reflect.Value) error {
+			if err := keyPlan.encode(enc, k); err != nil {
+				return err
+			}
+			return valPlan.encode(enc, v)
+		})
+	}
+}
+
+func (c *planCompiler) compileListEncode(t reflect.Type) encodeFunc {
+	elemtype := fieldType(t.Elem())
+	elemPlan := c.encodePlan(t.Elem(), elemtype)
+	return func(enc *encoder, val reflect.Value) error {
+		enc.mlist = MList{ElementType: elemtype, Count: val.Len()}
+		if err := enc.proto.WriteListBegin(enc.writer, &enc.mlist); err != nil {
+			return err
+		}
+		for i, n := 0, val.Len(); i < n; i++ {
+			if err := elemPlan.encode(enc, val.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (c *planCompiler) compileSetEncode(t reflect.Type) encodeFunc {
+	elemtype := fieldType(t.Elem())
+	elemPlan := c.encodePlan(t.Elem(), elemtype)
+	return func(enc *encoder, val reflect.Value) error {
+		enc.mset = MSet{ElementType: elemtype, Count: val.Len()}
+		if err := enc.proto.WriteSetBegin(enc.writer, &enc.mset); err != nil {
+			return err
+		}
+		for i, n := 0, val.Len(); i < n; i++ {
+			if err := elemPlan.encode(enc, val.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// compileMapSetEncode writes the keys of a map backed set, map[T]bool sets only those set to true
+func (c *planCompiler) compileMapSetEncode(t reflect.Type) encodeFunc {
+	elemtype := fieldType(t.Key())
+	elemPlan := c.encodePlan(t.Key(), elemtype)
+	isBool := t.Elem().Kind() == reflect.Bool
+	return func(enc *encoder, val reflect.Value) error {
+		enc.mset = MSet{ElementType: elemtype, Count: val.Len()}
+		if isBool {
+			enc.mset.Count = 0
+			for it := val.MapRange(); it.Next(); {
+				if it.Value().Bool() {
+					enc.mset.Count++
+				}
+			}
+		}
+		if err := enc.proto.WriteSetBegin(enc.writer, &enc.mset); err != nil {
+			return err
+		}
+		return enc.rangeMap(val, func(k, v reflect.Value) error {
+			if isBool && !v.Bool() {
+				return nil
+			}
+			return elemPlan.encode(enc, k)
+		})
+	}
+}
+
+// rangeMap calls f with the entries of map val, in key order in deterministic mode
+func (enc *encoder) rangeMap(val reflect.Value, f func(k, v reflect.Value) error) error {
+	if enc.opts.deterministic() {
+		for _, k := range enc.mapKeys(val) {
+			if err := f(k, val.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for it := val.MapRange(); it.Next(); {
+		if err := f(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode plans
+
+func decodeUnsupported(dec *decoder, wire byte, val reflect.Value) error {
+	return &UnsupportedTypeError{Type: val.Type()}
+}
+
+// enter counts the depth of containers, leave is called when one was read
+func (dec *decoder) enter(wire byte) error {
+	switch wire {
+	case MT_STRUCT, MT_MAP, MT_LIST, MT_SET:
+		dec.depth++
+		return dec.opts.checkDepth(dec.depth)
+	}
+	return nil
+}
+
+func (dec *decoder) leave(wire byte) {
+	switch wire {
+	case MT_STRUCT, MT_MAP, MT_LIST, MT_SET:
+		dec.depth--
+	}
+}
+
+func (c *planCompiler) compileDecode(t reflect.Type) decodeFunc {
+	if t.Kind() == reflect.Ptr && t.Implements(unmarshalerType) {
+		return func(dec *decoder, wire byte, val reflect.Value) error {
+			if err := dec.enter(wire); err != nil {
+				return err
+			}
+			if val.IsNil() {
+				val.Set(reflect.New(t.Elem()))
+			}
+			if err := val.Interface().(Unmarshaler).UnmarshalMsglib(dec.reader, dec.proto); err != nil {
+				return err
+			}
+			dec.leave(wire)
+			return nil
+		}
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return func(dec *decoder, wire byte, val reflect.Value) error {
+			if err := dec.enter(wire); err != nil {
+				return err
+			}
+			if err := val.Addr().Interface().(Unmarshaler).UnmarshalMsglib(dec.reader, dec.proto); err != nil {
+				return err
+			}
+			dec.leave(wire)
+			return nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := c.decodePlan(t.Elem())
+		return func(dec *decoder, wire byte, val reflect.Value) error {
+			if val.IsNil() {
+				val.Set(reflect.New(t.Elem()))
+			}
+			return elem.decode(dec, wire, val.Elem())
+		}
+	case reflect.Bool:
+		return decodeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return decodeInt
+	case reflect.Float32, reflect.Float64:
+		return decodeFloat
+	case reflect.String:
+		return decodeString
+	case reflect.Slice:
+		return c.compileSliceDecode(t)
+	case reflect.Array:
+		return c.compileArrayDecode(t)
+	case reflect.Map:
+		return c.compileMapDecode(t)
+	case reflect.Struct:
+		return c.compileStructDecode(t)
+	}
+	return decodeUnsupported
+}
+
+func decodeBool(dec *decoder, wire byte, val reflect.Value) error {
+	if wire != MT_BOOL {
+		return decodeUnsupported(dec, wire, val)
+	}
+	b, err := dec.proto.ReadBool(dec.reader)
+	if err != nil {
+		return err
+	}
+	val.SetBool(b)
+	return nil
+}
+
+func decodeInt(dec *decoder, wire byte, val reflect.Value) error {
+	switch wire {
+	case MT_BYTE:
+		b, err := dec.proto.ReadByte(dec.reader)
+		if err != nil {
+			return err
+		}
+		if isUintKind(val.Kind()) {
+			val.SetUint(uint64(b))
+		} else {
+			val.SetInt(int64(int8(b)))
+		}
+		return nil
+	case MT_I16, MT_I32, MT_I64:
+		return dec.readInt(wire, val)
+	}
+	return decodeUnsupported(dec, wire, val)
+}
+
+func decodeFloat(dec *decoder, wire byte, val reflect.Value) error {
+	switch wire {
+	case MT_FLOAT:
+		f, err := dec.proto.ReadFloat32(dec.reader)
+		if err != nil {
+			return err
+		}
+		val.SetFloat(float64(f))
+		return nil
+	case MT_DOUBLE:
+		f, err := dec.proto.ReadFloat64(dec.reader)
+		if err != nil {
+			return err
+		}
+		val.SetFloat(f)
+		return nil
+	}
+	return decodeUnsupported(dec, wire, val)
+}
+
+// decodeString reads MT_STRING or MT_BINARY, the same on the wire, see compatibleType
+func decodeString(dec *decoder, wire byte, val reflect.Value) error {
+	if wire != MT_STRING && wire != MT_BINARY {
+		return decodeUnsupported(dec, wire, val)
+	}
+	s, err := dec.proto.ReadString(dec.reader)
+	if err != nil {
+		return err
+	}
+	if err = dec.opts.checkLength(len(s)); err != nil {
+		return err
+	}
+	val.SetString(s)
+	return nil
+}
+
+// readBytes reads MT_BINARY or MT_STRING into bytes
+func (dec *decoder) readBytes() ([]byte, error) {
+	b, err := dec.proto.ReadBinary(dec.reader)
+	if err != nil {
+		return nil, err
+	}
+	return b, dec.opts.checkLength(len(b))
+}
+
+func (c *planCompiler) compileSliceDecode(t reflect.Type) decodeFunc {
+	readList := c.compileListDecode(t)
+	if t.Elem().Kind() != reflect.Uint8 {
+		return readList
+	}
+	isBytes := t.Elem().Name() == "uint8" || t.Elem().Name() == "byte"
+	return func(dec *decoder, wire byte, val reflect.Value) error {
+		if wire != MT_BINARY && wire != MT_STRING {
+			return readList(dec, wire, val)
+		}
+		if !isBytes {
+			return &UnsupportedValueError{Value: val, Message: "expect a byte array"}
+		}
+		b, err := dec.readBytes()
+		if err != nil {
+			return err
+		}
+		val.SetBytes(b)
+		return nil
+	}
+}
+
+// compileListDecode appends elements of MT_LIST or MT_SET to a slice
+func (c *planCompiler) compileListDecode(t reflect.Type) decodeFunc {
+	elemtype := t.Elem()
+	local := elemFieldType(elemtype)
+	elemPlan := c.decodePlan(elemtype)
+	zero := reflect.Zero(elemtype)
+	return func(dec *decoder, wire byte, val reflect.Value) error {
+		if wire != MT_LIST && wire != MT_SET {
+			return decodeUnsupported(dec, wire, val)
+		}
+		if err := dec.enter(wire); err != nil {
+			return err
+		}
+		mlist, err := readListMarker(dec.proto, dec.reader)
+		if err != nil {
+			return err
+		}
+		if err = dec.opts.checkCount(mlist.Count); err != nil {
+			return err
+		}
+		if err = dec.checkElemType(mlist.ElementType, local, val); err != nil {
+			return err
+		}
+		n := val.Len()
+		if err = dec.growSlice(val, mlist.Count); err != nil {
+			return err
+		}
+		for i := 0; i < mlist.Count; i++ {
+			if n+i == val.Len() {
+				if n+i < val.Cap() {
+					val.SetLen(n + i + 1)
+				} else {
+					val.Set(reflect.Append(val, zero))
+				}
+			}
+			elem := val.Index(n + i)
+			elem.Set(zero)
+			dec.pushIndex(i)
+			if err = elemPlan.decode(dec, mlist.ElementType, elem); err != nil {
+				return err
+			}
+			dec.pop()
+		}
+		dec.leave(wire)
+		return nil
+	}
+}
+
+// maxGrow bounds room reserved for elements of a stream before they are read
+const maxGrow = 1024
+
+// growSlice reserves room for count more elements of slice val. Every element takes
+// a byte at least, counts beyond the rest of a payload are truncated messages.
+func (dec *decoder) growSlice(val reflect.Value, count int) error {
+	if r, ok := dec.reader.(*sliceReader); ok {
+		if count > r.Len() {
+			return io.ErrUnexpectedEOF
+		}
+	} else if count > maxGrow {
+		count = maxGrow
+	}
+	n := val.Len()
+	if n+count <= val.Cap() {
+		return nil
+	}
+	grown := reflect.MakeSlice(val.Type(), n, n+count)
+	reflect.Copy(grown, val)
+	val.Set(grown)
+	return nil
+}
+
+func (c *planCompiler) compileArrayDecode(t reflect.Type) decodeFunc {
+	elemtype := t.Elem()
+	local := elemFieldType(elemtype)
+	elemPlan := c.decodePlan(elemtype)
+	isBytes := elemtype.Kind() == reflect.Uint8
+	return func(dec *decoder, wire byte, val reflect.Value) error {
+		switch wire {
+		case MT_BINARY, MT_STRING:
+			if !isBytes {
+				break
+			}
+			b, err := dec.readBytes()
+			if err != nil {
+				return err
+			}
+			if err = dec.checkArrayLen(val, len(b)); err != nil {
+				return err
+			}
+			reflect.Copy(val, reflect.ValueOf(b))
+			return nil
+		case MT_LIST, MT_SET:
+			if err := dec.enter(wire); err != nil {
+				return err
+			}
+			mlist, err := readListMarker(dec.proto, dec.reader)
+			if err != nil {
+				return err
+			}
+			if err = dec.opts.checkCount(mlist.Count); err != nil {
+				return err
+			}
+			if err = dec.checkElemType(mlist.ElementType, local, val); err != nil {
+				return err
+			}
+			if err = dec.checkArrayLen(val, mlist.Count); err != nil {
+				return err
+			}
+			for i := 0; i < mlist.Count; i++ {
+				dec.pushIndex(i)
+				if err = elemPlan.decode(dec, mlist.ElementType, val.Index(i)); err != nil {
+					return err
+				}
+				dec.pop()
+			}
+			dec.leave(wire)
+			return nil
+		}
+		return decodeUnsupported(dec, wire, val)
+	}
+}
+
+// compileMapDecode reads MT_MAP, or MT_SET and MT_LIST into the keys of a map backed set
+func (c *planCompiler) compileMapDecode(t reflect.Type) decodeFunc {
+	keytype, valtype := t.Key(), t.Elem()
+	keyLocal, valLocal := elemFieldType(keytype), elemFieldType(valtype)
+	keyPlan, valPlan := c.decodePlan(keytype), c.decodePlan(valtype)
+	keyZero, valZero := reflect.Zero(keytype), reflect.Zero(valtype)
+	setValue := valZero
+	if valtype.Kind() == reflect.Bool {
+		setValue = reflect.ValueOf(true).Convert(valtype)
+	}
+	return func(dec *decoder, wire byte, val reflect.Value) error {
+		if wire != MT_MAP && wire != MT_SET && wire != MT_LIST {
+			return decodeUnsupported(dec, wire, val)
+		}
+		if err := dec.enter(wire); err != nil {
+			return err
+		}
+		var mmap MMap
+		if wire == MT_MAP {
+			var err error
+			if mmap, err = readMapMarker(dec.proto, dec.reader); err != nil {
+				return err
+			}
+		} else {
+			mlist, err := readListMarker(dec.proto, dec.reader)
+			if err != nil {
+				return err
+			}
+			mmap = MMap{KeyType: mlist.ElementType, Count: mlist.Count}
+		}
+		if err := dec.opts.checkCount(mmap.Count); err != nil {
+			return err
+		}
+		if err := dec.checkElemType(mmap.KeyType, keyLocal, val); err != nil {
+			return err
+		}
+		if wire == MT_MAP {
+			if err := dec.checkElemType(mmap.ValueType, valLocal, val); err != nil {
+				return err
+			}
+		}
+		m := reflect.MakeMap(t)
+		key, elem := reflect.New(keytype).Elem(), reflect.New(valtype).Elem()
+		for i := 0; i < mmap.Count; i++ {
+			key.Set(keyZero)
+			dec.pushIndex(i)
+			if err := keyPlan.decode(dec, mmap.KeyType, key); err != nil {
+				return err
+			}
+			if wire != MT_MAP {
+				dec.pop()
+				m.SetMapIndex(key, setValue)
+				continue
+			}
+			elem.Set(valZero)
+			if err := valPlan.decode(dec, mmap.ValueType, elem); err != nil {
+				return err
+			}
+			dec.pop()
+			m.SetMapIndex(key, elem)
+		}
+		val.Set(m)
+		dec.leave(wire)
+		return nil
+	}
+}
+
+type decodeFieldPlan struct {
+	encodeField
+	plan *decodePlan
+}
+
+// maxDenseID bounds field ids looked up in a slice rather than a map
+const maxDenseID = 256
+
+func (c *planCompiler) compileStructDecode(t reflect.Type) decodeFunc {
+	meta := encodeFields(t)
+	var (
+		dense  []*decodeFieldPlan
+		sparse map[int]*decodeFieldPlan
+	)
+	if n := len(meta.sorted); n > 0 && meta.sorted[n-1].ID < maxDenseID {
+		dense = make([]*decodeFieldPlan, meta.sorted[n-1].ID+1)
+	} else {
+		sparse = make(map[int]*decodeFieldPlan, n)
+	}
+	for _, ef := range meta.sorted {
+		f := &decodeFieldPlan{encodeField: meta.fields[ef.ID], plan: c.decodePlan(ef.GoType)}
+		if dense != nil {
+			dense[ef.ID] = f
+		} else {
+			sparse[ef.ID] = f
+		}
+	}
+	lookup := func(id int) *decodeFieldPlan {
+		if dense == nil {
+			return sparse[id]
+		}
+		if id < 0 || id >= len(dense) {
+			return nil
+		}
+		return dense[id]
+	}
+
+	return func(dec *decoder, wire byte, val reflect.Value) error {
+		if wire != MT_STRUCT {
+			return decodeUnsupported(dec, wire, val)
+		}
+		if err := dec.enter(wire); err != nil {
+			return err
+		}
+		if _, err := dec.proto.ReadStructBegin(dec.reader); err != nil {
+			return err
+		}
+		for _, ef := range meta.defaults {
+			// absent fields keep the default, present ones are overwritten below
+			field, err := fieldByIndexAlloc(val, ef.Index)
+			if err != nil {
+				return err
+			}
+			field.Set(ef.defaultValue)
+		}
+		var unknown reflect.Value
+		if meta.unknown != nil {
+			var err error
+			if unknown, err = fieldByIndexAlloc(val, meta.unknown); err != nil {
+				return err
+			}
+			unknown.SetBytes(nil)
+		}
+		var seen []bool
+		if len(meta.required) > 0 {
+			seen = make([]bool, len(meta.required))
+		}
+		for {
+			mfield, err := readFieldMarker(dec.proto, dec.reader)
+			if err != nil {
+				return err
+			}
+			if mfield.Type == MT_NULL {
+				break
+			}
+
+			f := lookup(mfield.ID)
+			if f == nil {
+				// unknown fields are named by id
+				dec.pushField(strconv.Itoa(mfield.ID))
+				if meta.unknown != nil {
+					err = dec.readUnknown(mfield, unknown)
+				} else {
+					err = skipValue(dec.reader, dec.proto, mfield.Type, dec.opts, dec.depth)
+				}
+				if err != nil {
+					return err
+				}
+				dec.pop()
+				continue
+			}
+			dec.pushField(f.Name)
+			if !dec.compatible(mfield.Type, f.Type) {
+				msg := "type mismatch: " + t.Name() + ", field: " + f.Name
+				return dec.mismatch(f.Type, mfield.Type, &UnsupportedValueError{Value: val, Message: msg})
+			}
+			field, err := fieldByIndexAlloc(val, f.Index)
+			if err != nil {
+				return err
+			}
+			if err = f.plan.decode(dec, mfield.Type, field); err != nil {
+				return err
+			}
+			if f.required >= 0 {
+				seen[f.required] = true
+			}
+			dec.pop()
+		}
+		var missing *MissingFieldError
+		for i, ok := range seen {
+			if !ok {
+				missing = addMissingField(missing, t, meta.required[i])
+			}
+		}
+		if missing != nil {
+			return missing
+		}
+		dec.leave(wire)
+		return nil
+	}
+}
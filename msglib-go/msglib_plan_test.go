@@ -0,0 +1,105 @@
+package msglib
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type msgTestNode struct {
+	Val      int32          `msglib:"1"`
+	Next     *msgTestNode   `msglib:"2"`
+	Children []*msgTestNode `msglib:"3"`
+}
+
+type msgTestChan struct {
+	Name string        `msglib:"1"`
+	Ch   chan struct{} `msglib:"2"`
+}
+
+func TestMsglibPlan(t *testing.T) {
+	// recursive types share their plan
+	obj := &msgTestNode{Val: 1, Next: &msgTestNode{Val: 2}, Children: []*msgTestNode{{Val: 3}, {Val: 4, Next: &msgTestNode{Val: 5}}}}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var res msgTestNode
+	if err = Deserialize(payload, &res); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(&res, obj) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, &res)
+	}
+
+	// types which cannot be encoded fail every time, without panics
+	for i := 0; i < 2; i++ {
+		var ute *UnsupportedTypeError
+		if _, err = Serialize(&msgTestChan{Name: "xixi"}); !errors.As(err, &ute) {
+			t.Fatalf("expect UnsupportedTypeError, got %v", err)
+		}
+		if err = Deserialize(payload, &msgTestChan{}); !errors.As(err, &ute) {
+			t.Fatalf("expect UnsupportedTypeError, got %v", err)
+		}
+	}
+
+	// lists are appended to slices, not replacing them
+	res.Children = res.Children[:1]
+	if err = Deserialize(payload, &res); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if len(res.Children) != 3 || res.Children[0].Val != 3 || res.Children[2].Next.Val != 5 {
+		t.Fatalf("data err, expect 3 children, got %+v", res.Children)
+	}
+
+	// counts beyond the payload are truncated messages, not allocated
+	payload, err = Serialize(&msgTest1{Name: "xixi"})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	buf := make([]byte, len(payload)-1, len(payload)+16)
+	copy(buf, payload)
+	var header [binary.MaxVarintLen64]byte
+	buf = append(buf, header[:binary.PutUvarint(header[:], 4<<4|uint64(MT_LIST))]...)
+	buf = append(buf, header[:binary.PutUvarint(header[:], 1<<24|uint64(MT_STRUCT))]...)
+	if err = Deserialize(buf, &msgTest1{}); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expect truncated message, got %v", err)
+	}
+}
+
+func newBenchMsg() *msgTest1 {
+	obj := &msgTest1{Name: "xixi", Age: 2820}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte("hello")}
+	obj.TagList = make([]*msgTest1_Tag, 8)
+	for i := range obj.TagList {
+		obj.TagList[i] = &msgTest1_Tag{Val: int32(2824 + i), Hash: []byte("xixi_" + strconv.Itoa(i))}
+	}
+	return obj
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	obj := newBenchMsg()
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := AppendSerialize(buf[:0], obj); err != nil {
+			b.Fatalf("serialize object failure: %+v", err)
+		}
+	}
+}
+
+func BenchmarkDeserialize(b *testing.B) {
+	payload, err := Serialize(newBenchMsg())
+	if err != nil {
+		b.Fatalf("serialize object failure: %+v", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var obj msgTest1
+		if err := Deserialize(payload, &obj); err != nil {
+			b.Fatalf("deserialize object failure: %+v", err)
+		}
+	}
+}
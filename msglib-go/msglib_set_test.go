@@ -0,0 +1,29 @@
+package msglib
+
+import (
+	"reflect"
+	"testing"
+)
+
+type msgTestMapSet struct {
+	Names map[string]struct{} `msglib:"1"`
+	IDs   map[int32]struct{}  `msglib:"2"`
+}
+
+func TestMsglibMapSet(t *testing.T) {
+	obj := &msgTestMapSet{
+		Names: map[string]struct{}{"a": {}, "b": {}},
+		IDs:   map[int32]struct{}{3: {}},
+	}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var res msgTestMapSet
+	if err = Deserialize(payload, &res); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(&res, obj) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, &res)
+	}
+}
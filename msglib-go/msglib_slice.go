@@ -0,0 +1,145 @@
+package msglib
+
+import (
+	"errors"
+	"io"
+)
+
+var errVarintOverflow = errors.New("msglib: varint overflows a 64-bit integer")
+
+// sliceReader reads a payload held in memory. The binary proto recognizes it
+// and reads varints, floats and binaries directly from the slice.
+type sliceReader struct {
+	buf []byte
+	off int
+}
+
+func newSliceReader(buf []byte) *sliceReader {
+	return &sliceReader{buf: buf}
+}
+
+func (sr *sliceReader) Len() int {
+	return len(sr.buf) - sr.off
+}
+
+func (sr *sliceReader) Read(p []byte) (int, error) {
+	if sr.off >= len(sr.buf) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, sr.buf[sr.off:])
+	sr.off += n
+	return n, nil
+}
+
+func (sr *sliceReader) ReadByte() (byte, error) {
+	if sr.off >= len(sr.buf) {
+		return 0, io.EOF
+	}
+	c := sr.buf[sr.off]
+	sr.off++
+	return c, nil
+}
+
+// next returns the following n bytes of the payload without copying
+func (sr *sliceReader) next(n uint64) ([]byte, error) {
+	if n > uint64(sr.Len()) {
+		if sr.Len() == 0 {
+			return nil, io.EOF
+		}
+		sr.off = len(sr.buf)
+		return nil, io.ErrUnexpectedEOF
+	}
+	start := sr.off
+	sr.off += int(n)
+	return sr.buf[start:sr.off:sr.off], nil
+}
+
+func (sr *sliceReader) readUvarint() (uint64, error) {
+	var x uint64
+	var s uint
+	for i := 0; sr.off < len(sr.buf); i++ {
+		c := sr.buf[sr.off]
+		sr.off++
+		if c < 0x80 {
+			if i > 9 || i == 9 && c > 1 {
+				return x, errVarintOverflow
+			}
+			return x | uint64(c)<<s, nil
+		}
+		x |= uint64(c&0x7f) << s
+		s += 7
+		if i >= 9 {
+			return x, errVarintOverflow
+		}
+	}
+	if s > 0 {
+		return x, io.ErrUnexpectedEOF
+	}
+	return x, io.EOF
+}
+
+func (sr *sliceReader) readVarint() (int64, error) {
+	ux, err := sr.readUvarint()
+	x := int64(ux >> 1)
+	if ux&1 != 0 {
+		x = ^x
+	}
+	return x, err
+}
+
+// markerReader is implemented by protos which return markers by value,
+// the decoder uses it to avoid allocating a marker per struct field and container.
+// Sets are read with readList, both protos encode them like lists.
+type markerReader interface {
+	readField(reader io.Reader) (MField, error)
+	readList(reader io.Reader) (MList, error)
+	readMap(reader io.Reader) (MMap, error)
+}
+
+func readFieldMarker(proto IMProto, reader io.Reader) (MField, error) {
+	if mr, ok := proto.(markerReader); ok {
+		return mr.readField(reader)
+	}
+	marker, err := proto.ReadFieldBegin(reader)
+	if err != nil {
+		return MField{}, err
+	}
+	return *marker, nil
+}
+
+func readListMarker(proto IMProto, reader io.Reader) (MList, error) {
+	if mr, ok := proto.(markerReader); ok {
+		return mr.readList(reader)
+	}
+	marker, err := proto.ReadListBegin(reader)
+	if err != nil {
+		return MList{}, err
+	}
+	return *marker, nil
+}
+
+func readSetMarker(proto IMProto, reader io.Reader) (MSet, error) {
+	if mr, ok := proto.(markerReader); ok {
+		list, err := mr.readList(reader)
+		return MSet{ElementType: list.ElementType, Count: list.Count}, err
+	}
+	marker, err := proto.ReadSetBegin(reader)
+	if err != nil {
+		return MSet{}, err
+	}
+	return *marker, nil
+}
+
+func readMapMarker(proto IMProto, reader io.Reader) (MMap, error) {
+	if mr, ok := proto.(markerReader); ok {
+		return mr.readMap(reader)
+	}
+	marker, err := proto.ReadMapBegin(reader)
+	if err != nil {
+		return MMap{}, err
+	}
+	return *marker, nil
+}
@@ -0,0 +1,81 @@
+package msglib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDeserializeAliasBytes(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: 3}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte("hello world")}
+	obj.TagList = []*msgTest1_Tag{{Val: 1, Hash: []byte("a")}, {Val: -2}}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize failure: %+v", err)
+	}
+
+	// slice path must decode like the stream path
+	var expect msgTest1
+	if err = DecodeStruct(struct{ io.Reader }{bytes.NewReader(payload)}, NewBinaryProto(), &expect); err != nil {
+		t.Fatalf("decode failure: %+v", err)
+	}
+
+	var copied msgTest1
+	if err = Deserialize(payload, &copied); err != nil {
+		t.Fatalf("deserialize failure: %+v", err)
+	}
+	if !reflect.DeepEqual(copied, expect) {
+		t.Fatalf("data err, expect %+v, got %+v", expect, copied)
+	}
+
+	var aliased msgTest1
+	opts := &DecodeOptions{AliasBytes: true}
+	if err = opts.Deserialize(payload, &aliased); err != nil {
+		t.Fatalf("deserialize failure: %+v", err)
+	}
+	if !reflect.DeepEqual(aliased, expect) {
+		t.Fatalf("data err, expect %+v, got %+v", expect, aliased)
+	}
+
+	// only the aliased value sees changes of the payload
+	idx := bytes.Index(payload, []byte("hello"))
+	payload[idx] = 'j'
+	if string(aliased.Tag.Hash) != "jello world" {
+		t.Fatalf("data err, expect aliased hash, got %q", aliased.Tag.Hash)
+	}
+	if string(copied.Tag.Hash) != "hello world" || aliased.Name != "xixi" {
+		t.Fatalf("data err, expect copies, got %q, %q", copied.Tag.Hash, aliased.Name)
+	}
+
+	// appending to an aliased field must not overwrite the payload
+	next := payload[idx+len("hello world")]
+	aliased.Tag.Hash = append(aliased.Tag.Hash, '!')
+	if payload[idx+len("hello world")] != next {
+		t.Fatalf("data err, append overwrote the payload")
+	}
+}
+
+func TestDeserializeTruncated(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: 300}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte("hello world")}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize failure: %+v", err)
+	}
+	for i := 1; i < len(payload); i++ {
+		var data msgTest1
+		if err = Deserialize(payload[:i], &data); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("expect truncated error for payload truncated at %v, got %v", i, err)
+		}
+	}
+
+	// 11 continuation bytes do not fit in 64 bits
+	payload = bytes.Repeat([]byte{0xff}, 11)
+	var data msgTest1
+	if err = Deserialize(payload, &data); !errors.Is(err, errVarintOverflow) || !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect varint overflow, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package msglib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// stream framing: every message is prefixed with its length as an unsigned varint
+//
+//   [uvarint length][payload]...
+//
+// With FrameOptions the payload is a sealed frame, see FrameOptions.Seal.
+
+// Encoder writes length prefixed messages to a stream
+type Encoder struct {
+	writer io.Writer
+	proto  IMProto
+	buffer bytes.Buffer
+	header []byte
+	opts   *EncodeOptions
+	// envelope keeps compressed frames
+	envelope []byte
+	frame    *FrameOptions
+	sealed   []byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		writer: w,
+		proto:  NewBinaryProto(),
+		header: make([]byte, binary.MaxVarintLen64),
+	}
+}
+
+// SetOptions sets encode options for following messages
+func (enc *Encoder) SetOptions(opts *EncodeOptions) {
+	enc.opts = opts
+}
+
+// SetFrameOptions checks or signs following messages, the Decoder needs the same options
+func (enc *Encoder) SetFrameOptions(opts *FrameOptions) {
+	enc.frame = opts
+}
+
+// Encode writes one message, the frame is written with a single call to the underlying writer
+func (enc *Encoder) Encode(data interface{}) error {
+	enc.buffer.Reset()
+	// reserve the longest header, the payload is moved next to the real header afterwards
+	enc.buffer.Write(enc.header[:binary.MaxVarintLen64])
+	if err := enc.opts.EncodeStruct(&enc.buffer, enc.proto, data); err != nil {
+		return err
+	}
+	frame := enc.buffer.Bytes()
+	if hasEnvelope(data) {
+		env, ok, err := enc.opts.compress(append(enc.envelope[:0], enc.header...), frame[binary.MaxVarintLen64:])
+		if err != nil {
+			return err
+		}
+		if ok {
+			enc.envelope = env
+			frame = env
+		}
+	}
+	if enc.frame != nil {
+		sealed, err := enc.frame.Seal(append(enc.sealed[:0], enc.header...), frame[binary.MaxVarintLen64:])
+		if err != nil {
+			return err
+		}
+		enc.sealed = sealed
+		frame = sealed
+	}
+	size := len(frame) - binary.MaxVarintLen64
+	n := binary.PutUvarint(enc.header, uint64(size))
+	start := binary.MaxVarintLen64 - n
+	copy(frame[start:], enc.header[:n])
+	_, err := enc.writer.Write(frame[start:])
+	return err
+}
+
+const (
+	maxInt     = int(^uint(0) >> 1)
+	frameChunk = 64 << 10 // frames allocated up front without MaxBytes
+)
+
+var errFrameSize = errors.New("msglib: frame size out of range")
+
+// Decoder reads length prefixed messages from a stream
+type Decoder struct {
+	reader *bufio.Reader
+	proto  IMProto
+	buffer []byte
+	opts   *DecodeOptions
+	frame  *FrameOptions
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{
+		reader: br,
+		proto:  NewBinaryProto(),
+	}
+}
+
+// SetOptions sets limits for following messages, MaxBytes limits the frame size.
+// With AliasBytes every frame is read into a new buffer.
+func (dec *Decoder) SetOptions(opts *DecodeOptions) {
+	dec.opts = opts
+}
+
+// SetFrameOptions verifies following frames with the options of the Encoder
+func (dec *Decoder) SetFrameOptions(opts *FrameOptions) {
+	dec.frame = opts
+}
+
+// readFrame reads a frame of n bytes. Without MaxBytes the size comes from an untrusted
+// stream, so long frames grow their buffer as bytes arrive rather than all at once.
+func (dec *Decoder) readFrame(n int, limited bool) ([]byte, error) {
+	var err error
+	if n <= cap(dec.buffer) && !dec.opts.aliasBytes() {
+		_, err = io.ReadFull(dec.reader, dec.buffer[:n])
+	} else if limited || n <= frameChunk {
+		// aliased []byte fields keep the frame, it can not be reused
+		dec.buffer = make([]byte, n)
+		_, err = io.ReadFull(dec.reader, dec.buffer)
+	} else {
+		buf := bytes.NewBuffer(make([]byte, 0, frameChunk))
+		_, err = io.CopyN(buf, dec.reader, int64(n))
+		dec.buffer = buf.Bytes()
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return dec.buffer[:n], nil
+}
+
+// Decode reads one message into data.
+// It returns io.EOF when the stream ends between frames,
+// and io.ErrUnexpectedEOF when the stream ends inside a frame.
+// Frames failing the checks of SetFrameOptions return ErrChecksum, ErrSignature
+// or UnknownKeyError and are not decoded, the stream goes on with the next frame.
+func (dec *Decoder) Decode(data interface{}) error {
+	size, err := binary.ReadUvarint(dec.reader)
+	if err != nil {
+		return err
+	}
+	limited := dec.opts != nil && dec.opts.MaxBytes > 0
+	if limited && size > uint64(dec.opts.MaxBytes) {
+		return &LimitError{Limit: "MaxBytes", Max: dec.opts.MaxBytes, Value: int64(size & math.MaxInt64)}
+	}
+	if size > uint64(maxInt) {
+		return errFrameSize
+	}
+	payload, err := dec.readFrame(int(size), limited)
+	if err != nil {
+		return err
+	}
+	// frames are verified before anything decodes them
+	if payload, err = dec.frame.Open(payload); err != nil {
+		return err
+	}
+	if isEnvelope(payload) && hasEnvelope(data) {
+		if payload, err = dec.opts.openEnvelope(payload); err != nil {
+			return err
+		}
+	}
+	// a frame shorter than the message fails with a DecodeError of kind ErrTruncated,
+	// which wraps io.ErrUnexpectedEOF
+	return dec.opts.DecodeStruct(newSliceReader(payload), dec.proto, data)
+}
@@ -0,0 +1,73 @@
+package msglib
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStreamCodec(t *testing.T) {
+	buff := &bytes.Buffer{}
+	enc := NewEncoder(buff)
+	for i := 0; i < 3; i++ {
+		obj := &msgTest1{Name: "xixi", Age: int32(i + 1)}
+		obj.Tag = &msgTest1_Tag{Val: 242, Hash: bytes.Repeat([]byte("x"), 100*i)}
+		if err := enc.Encode(obj); err != nil {
+			t.Fatalf("encode failure: %+v", err)
+		}
+	}
+	payload := buff.Bytes()
+
+	// iotest style reader without io.ByteReader
+	dec := NewDecoder(struct{ io.Reader }{bytes.NewReader(payload)})
+	for i := 0; i < 3; i++ {
+		var obj msgTest1
+		if err := dec.Decode(&obj); err != nil {
+			t.Fatalf("decode failure: %+v", err)
+		}
+		if obj.Age != int32(i+1) || len(obj.Tag.Hash) != 100*i {
+			t.Fatalf("data err, frame %v, got %+v", i, obj)
+		}
+	}
+	var obj msgTest1
+	if err := dec.Decode(&obj); err != io.EOF {
+		t.Fatalf("expect io.EOF between frames, got %v", err)
+	}
+
+	// truncated inside the last frame
+	dec = NewDecoder(bytes.NewReader(payload[:len(payload)-1]))
+	var err error
+	for err == nil {
+		err = dec.Decode(&obj)
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect io.ErrUnexpectedEOF inside frame, got %v", err)
+	}
+}
+
+func TestStreamHostilePrefix(t *testing.T) {
+	// a length beyond int, and a huge length with no data behind it
+	for _, prefix := range [][]byte{
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 1, 2, 3},
+	} {
+		var obj msgTest1
+		if err := NewDecoder(bytes.NewReader(prefix)).Decode(&obj); err == nil {
+			t.Fatalf("expect failure of prefix % x", prefix)
+		}
+	}
+
+	// frames longer than a chunk still decode without MaxBytes
+	buff := &bytes.Buffer{}
+	obj := &msgTest1{Name: "xixi", Tag: &msgTest1_Tag{Hash: bytes.Repeat([]byte("x"), 3*frameChunk)}}
+	if err := NewEncoder(buff).Encode(obj); err != nil {
+		t.Fatalf("encode failure: %+v", err)
+	}
+	var res msgTest1
+	if err := NewDecoder(buff).Decode(&res); err != nil {
+		t.Fatalf("decode failure: %+v", err)
+	}
+	if !bytes.Equal(res.Tag.Hash, obj.Tag.Hash) {
+		t.Fatalf("data err, expect %v bytes, got %v", len(obj.Tag.Hash), len(res.Tag.Hash))
+	}
+}
@@ -0,0 +1,381 @@
+package msglib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// text mode wire format, compatible with java MTextProto / MemoryTextBuffer
+// and javascript MTextProto / TextBuffer:
+//
+//   - every value is a decimal or string token, tokens are separated by ';'
+//   - '\' and ';' inside a token are escaped as '\\' and '\;'
+//   - field, list and map headers are written as decimal integers
+//   - floats are written as decimal text, the same as javascript Number.toString.
+//     Java writes Double.toString, e.g. "1.0" and "1.0E-5" instead of "1" and "1e-5",
+//     so text of floats is not byte-identical to java, both forms are read.
+//   - binary data, unsupported by java and javascript, is a base64 (std encoding) token
+
+const (
+	textSeparator = ';'
+	textEscape    = '\\'
+)
+
+// implements IMProto interface
+type mTextProto struct {
+	needSeparator bool
+	depth         int // of structs being written
+	readBuffer    []byte
+	token         bytes.Buffer
+	opts          *DecodeOptions
+}
+
+// NewTextProto returns a text proto. It remembers whether a separator is needed before
+// the next token, a new top level struct starts without one, so the proto can be
+// reused for the next message once a struct was written completely. Messages which
+// are not structs, or follow a failed write, need a new proto.
+func NewTextProto() IMProto {
+	proto := &mTextProto{
+		readBuffer: make([]byte, 1),
+	}
+	return proto
+}
+
+func (txt *mTextProto) setDecodeOptions(opts *DecodeOptions) {
+	txt.opts = opts
+}
+
+// checkTokenLength allows room for base64, the decoder checks decoded lengths again
+func (txt *mTextProto) checkTokenLength(n int) error {
+	if txt.opts == nil || txt.opts.MaxBinaryLength <= 0 {
+		return nil
+	}
+	if n > base64.StdEncoding.EncodedLen(txt.opts.MaxBinaryLength) {
+		return &LimitError{Limit: "MaxBinaryLength", Max: int64(txt.opts.MaxBinaryLength), Value: int64(n)}
+	}
+	return nil
+}
+
+func (txt *mTextProto) readToken(reader io.Reader) (string, error) {
+	br, ok := reader.(io.ByteReader)
+	if !ok {
+		br = newByteReader(reader, txt.readBuffer)
+	}
+	txt.token.Reset()
+	for n := 0; ; n++ {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			if n == 0 {
+				return "", io.EOF
+			}
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if c == textSeparator {
+			break
+		}
+		if err := txt.checkTokenLength(txt.token.Len() + 1); err != nil {
+			return "", err
+		}
+		if c == textEscape {
+			next, err := br.ReadByte()
+			if err == io.EOF {
+				txt.token.WriteByte(c)
+				break
+			} else if err != nil {
+				return "", err
+			}
+			if next != textEscape && next != textSeparator {
+				// not an escape sequence, keep both characters
+				txt.token.WriteByte(c)
+			}
+			txt.token.WriteByte(next)
+			continue
+		}
+		txt.token.WriteByte(c)
+	}
+	return txt.token.String(), nil
+}
+
+func (txt *mTextProto) writeToken(writer io.Writer, token string) error {
+	txt.token.Reset()
+	if txt.needSeparator {
+		txt.token.WriteByte(textSeparator)
+	}
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		if c == textEscape || c == textSeparator {
+			txt.token.WriteByte(textEscape)
+		}
+		txt.token.WriteByte(c)
+	}
+	txt.needSeparator = true
+	_, err := writer.Write(txt.token.Bytes())
+	return err
+}
+
+func (txt *mTextProto) readInt(reader io.Reader, bitSize int) (int64, error) {
+	token, err := txt.readToken(reader)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseInt(token, 10, bitSize)
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+		wiretype := map[int]byte{16: MT_I16, 32: MT_I32, 64: MT_I64}[bitSize]
+		return 0, &OverflowError{Value: token, Type: typeNames[wiretype]}
+	}
+	return val, err
+}
+
+func (txt *mTextProto) writeInt(writer io.Writer, val int64) error {
+	return txt.writeToken(writer, strconv.FormatInt(val, 10))
+}
+
+func (txt *mTextProto) ReadStructBegin(reader io.Reader) (*MStruct, error) {
+	return nil, nil
+}
+
+func (txt *mTextProto) WriteStructBegin(writer io.Writer, marker *MStruct) error {
+	if txt.depth == 0 {
+		txt.needSeparator = false
+	}
+	txt.depth++
+	return nil
+}
+
+func (txt *mTextProto) ReadFieldBegin(reader io.Reader) (*MField, error) {
+	field, err := txt.readField(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &field, nil
+}
+
+func (txt *mTextProto) readField(reader io.Reader) (field MField, err error) {
+	idAndType, err := txt.readInt(reader, 32)
+	if err != nil {
+		return
+	}
+	field.Type = byte(idAndType & 0x0F)
+	field.ID = int(idAndType >> 4)
+	return
+}
+
+func (txt *mTextProto) WriteFieldBegin(writer io.Writer, marker *MField) error {
+	idAndType := ((int32(marker.ID) << 4) | (int32(marker.Type) & 0x0F))
+	return txt.writeInt(writer, int64(idAndType))
+}
+
+func (txt *mTextProto) WriteFieldStop(writer io.Writer) error {
+	if txt.depth > 0 {
+		txt.depth--
+	}
+	marker := &MField{Name: "", Type: MT_NULL, ID: 0}
+	return txt.WriteFieldBegin(writer, marker)
+}
+
+func (txt *mTextProto) ReadMapBegin(reader io.Reader) (*MMap, error) {
+	marker, err := txt.readMap(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &marker, nil
+}
+
+func (txt *mTextProto) readMap(reader io.Reader) (marker MMap, err error) {
+	count, err := txt.readInt(reader, 32)
+	if err != nil {
+		return
+	}
+	tval, err := txt.ReadByte(reader)
+	if err != nil {
+		return
+	}
+	marker.Count = int(count)
+	marker.KeyType = byte(tval & 0x0F)
+	marker.ValueType = byte(tval >> 4)
+	return
+}
+
+func (txt *mTextProto) WriteMapBegin(writer io.Writer, marker *MMap) error {
+	if err := txt.writeInt(writer, int64(marker.Count)); err != nil {
+		return err
+	}
+	key := byte(marker.KeyType)
+	val := byte(marker.ValueType)
+	tval := byte(((val & 0x0F) << 4) | (key & 0x0F))
+	// unsigned, the same as javascript
+	return txt.writeInt(writer, int64(tval))
+}
+
+func (txt *mTextProto) ReadListBegin(reader io.Reader) (*MList, error) {
+	list, err := txt.readList(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (txt *mTextProto) readList(reader io.Reader) (list MList, err error) {
+	countAndType, err := txt.readInt(reader, 32)
+	if err != nil {
+		return
+	}
+	list.ElementType = byte(countAndType & 0x0F)
+	list.Count = int(countAndType >> 4)
+	return
+}
+
+func (txt *mTextProto) WriteListBegin(writer io.Writer, marker *MList) error {
+	countAndType := ((int32(marker.Count) << 4) | int32(marker.ElementType&0x0F))
+	return txt.writeInt(writer, int64(countAndType))
+}
+
+func (txt *mTextProto) ReadSetBegin(reader io.Reader) (*MSet, error) {
+	list, err := txt.readList(reader)
+	if err != nil {
+		return nil, err
+	}
+	set := &MSet{ElementType: list.ElementType, Count: list.Count}
+	return set, nil
+}
+
+func (txt *mTextProto) WriteSetBegin(writer io.Writer, marker *MSet) error {
+	list := &MList{}
+	list.Count = marker.Count
+	list.ElementType = marker.ElementType
+	return txt.WriteListBegin(writer, list)
+}
+
+func (txt *mTextProto) ReadBool(reader io.Reader) (bool, error) {
+	val, err := txt.ReadByte(reader)
+	return (val == 1), err
+}
+
+func (txt *mTextProto) WriteBool(writer io.Writer, data bool) error {
+	var val byte
+	if data {
+		val = byte(1)
+	} else {
+		val = byte(0)
+	}
+	return txt.WriteByte(writer, val)
+}
+
+func (txt *mTextProto) ReadByte(reader io.Reader) (byte, error) {
+	token, err := txt.readToken(reader)
+	if err != nil {
+		return 0, err
+	}
+	// java writes signed bytes, javascript writes unsigned bytes
+	val, err := strconv.ParseInt(token, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	if val < math.MinInt8 || val > math.MaxUint8 {
+		return 0, errors.New("byte out of range in msglib.TextProto.ReadByte: " + token)
+	}
+	return byte(val), nil
+}
+
+func (txt *mTextProto) WriteByte(writer io.Writer, data byte) error {
+	// signed, the same as java
+	return txt.writeInt(writer, int64(int8(data)))
+}
+
+func (txt *mTextProto) ReadI16(reader io.Reader) (int16, error) {
+	val, err := txt.readInt(reader, 16)
+	return int16(val), err
+}
+
+func (txt *mTextProto) WriteI16(writer io.Writer, data int16) error {
+	return txt.writeInt(writer, int64(data))
+}
+
+func (txt *mTextProto) ReadI32(reader io.Reader) (int32, error) {
+	val, err := txt.readInt(reader, 32)
+	return int32(val), err
+}
+
+func (txt *mTextProto) WriteI32(writer io.Writer, data int32) error {
+	return txt.writeInt(writer, int64(data))
+}
+
+func (txt *mTextProto) ReadI64(reader io.Reader) (int64, error) {
+	return txt.readInt(reader, 64)
+}
+
+func (txt *mTextProto) WriteI64(writer io.Writer, data int64) error {
+	return txt.writeInt(writer, data)
+}
+
+func (txt *mTextProto) ReadFloat32(reader io.Reader) (float32, error) {
+	val, err := txt.ReadFloat64(reader)
+	return float32(val), err
+}
+
+func (txt *mTextProto) WriteFloat32(writer io.Writer, data float32) error {
+	// java and javascript both widen float to double before formatting
+	return txt.WriteFloat64(writer, float64(data))
+}
+
+func (txt *mTextProto) ReadFloat64(reader io.Reader) (float64, error) {
+	token, err := txt.readToken(reader)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(token, 64)
+}
+
+func (txt *mTextProto) WriteFloat64(writer io.Writer, data float64) error {
+	return txt.writeToken(writer, formatTextFloat(data))
+}
+
+func (txt *mTextProto) ReadBinary(reader io.Reader) ([]byte, error) {
+	token, err := txt.readToken(reader)
+	if err != nil || token == "" {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(token)
+}
+
+func (txt *mTextProto) WriteBinary(writer io.Writer, binary []byte) error {
+	return txt.writeToken(writer, base64.StdEncoding.EncodeToString(binary))
+}
+
+func (txt *mTextProto) ReadString(reader io.Reader) (string, error) {
+	return txt.readToken(reader)
+}
+
+func (txt *mTextProto) WriteString(writer io.Writer, str string) error {
+	return txt.writeToken(writer, str)
+}
+
+// formatTextFloat formats val the same way as javascript Number.prototype.toString
+func formatTextFloat(val float64) string {
+	switch {
+	case math.IsNaN(val):
+		return "NaN"
+	case math.IsInf(val, 1):
+		return "Infinity"
+	case math.IsInf(val, -1):
+		return "-Infinity"
+	}
+	abs := math.Abs(val)
+	if abs == 0 {
+		return "0"
+	} else if abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	// go writes exponent as 'e-07', javascript as 'e-7'
+	str := strconv.FormatFloat(val, 'e', -1, 64)
+	idx := strings.IndexByte(str, 'e')
+	mantissa, sign, exp := str[:idx], str[idx+1], strings.TrimLeft(str[idx+2:], "0")
+	return mantissa + "e" + string(sign) + exp
+}
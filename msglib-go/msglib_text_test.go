@@ -0,0 +1,118 @@
+package msglib
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+type msgTextTest struct {
+	Name string `msglib:"1"`
+}
+
+func TestTextProtoWireFormat(t *testing.T) {
+	buff := &bytes.Buffer{}
+	obj := &msgTextTest{Name: `a;b\c`}
+	if err := EncodeStruct(buff, NewTextProto(), obj); err != nil {
+		t.Fatalf("encode failure: %+v", err)
+	}
+	// field header 1<<4|MT_STRING, escaped name, field stop
+	expect := `26;a\;b\\c;1`
+	if buff.String() != expect {
+		t.Fatalf("text err, expect %v, got %v", expect, buff.String())
+	}
+
+	var obj2 msgTextTest
+	if err := DecodeStruct(bytes.NewBufferString(expect), NewTextProto(), &obj2); err != nil {
+		t.Fatalf("decode failure: %+v", err)
+	}
+	if obj2.Name != obj.Name {
+		t.Fatalf("data err, expect %v, got %v", obj.Name, obj2.Name)
+	}
+
+	// a reused proto starts the next message without a separator
+	proto := NewTextProto()
+	for i := 0; i < 2; i++ {
+		buff.Reset()
+		if err := EncodeStruct(buff, proto, &msgTest1{Name: "x", Tag: &msgTest1_Tag{Val: 1}}); err != nil {
+			t.Fatalf("encode failure: %+v", err)
+		}
+		if buff.Bytes()[0] == ';' {
+			t.Fatalf("text err, expect no leading separator, got %v", buff.String())
+		}
+	}
+}
+
+func TestTextProtoCodec(t *testing.T) {
+	obj := new(msgTest1)
+	obj.Name = "xixi;haha"
+	obj.Age = -2820
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte("hello")}
+	obj.TagList = []*msgTest1_Tag{
+		{Val: 2824, Hash: []byte("xixi_0")},
+		{Val: 2825, Hash: []byte("xixi_1")},
+	}
+
+	buff := &bytes.Buffer{}
+	if err := EncodeStruct(buff, NewTextProto(), obj); err != nil {
+		t.Fatalf("encode failure: %+v", err)
+	}
+	t.Logf("text = %s", buff.String())
+
+	var obj2 msgTest1
+	if err := DecodeStruct(buff, NewTextProto(), &obj2); err != nil {
+		t.Fatalf("decode failure: %+v", err)
+	}
+	if obj2.Name != obj.Name || obj2.Age != obj.Age {
+		t.Fatalf("data err, expect %+v, got %+v", obj, obj2)
+	}
+	if string(obj2.Tag.Hash) != string(obj.Tag.Hash) || obj2.Tag.Val != obj.Tag.Val {
+		t.Fatalf("data err, expect %+v, got %+v", obj.Tag, obj2.Tag)
+	}
+	if len(obj2.TagList) != 2 || string(obj2.TagList[1].Hash) != "xixi_1" {
+		t.Fatalf("data err, expect %+v, got %+v", obj.TagList, obj2.TagList)
+	}
+}
+
+func TestTextProtoFloat(t *testing.T) {
+	cases := map[float64]string{
+		1:            "1",
+		-0.5:         "-0.5",
+		1e21:         "1e+21",
+		1.5e-7:       "1.5e-7",
+		123456.789:   "123456.789",
+		math.Inf(-1): "-Infinity",
+	}
+	for val, expect := range cases {
+		buff := &bytes.Buffer{}
+		proto := NewTextProto()
+		if err := proto.WriteFloat64(buff, val); err != nil {
+			t.Fatalf("write float64 failure: %+v", err)
+		}
+		if buff.String() != expect {
+			t.Fatalf("text err, expect %v, got %v", expect, buff.String())
+		}
+		if got, err := proto.ReadFloat64(buff); err != nil || got != val {
+			t.Fatalf("read float64 failure or not match: %v, %+v", got, err)
+		}
+	}
+
+	// java Double.toString and Float.toString output
+	java := map[string]float64{
+		"1.0":       1,
+		"-0.5":      -0.5,
+		"1.0E21":    1e21,
+		"1.5E-7":    1.5e-7,
+		"1.0E-5":    1e-5,
+		"1.2345E10": 1.2345e10,
+		"-Infinity": math.Inf(-1),
+	}
+	for text, expect := range java {
+		if got, err := NewTextProto().ReadFloat64(bytes.NewBufferString(text)); err != nil || got != expect {
+			t.Fatalf("read float64 failure or not match: %v, expect %v, %+v", got, expect, err)
+		}
+	}
+	if got, err := NewTextProto().ReadFloat64(bytes.NewBufferString("NaN")); err != nil || !math.IsNaN(got) {
+		t.Fatalf("read float64 failure or not match: %v, %+v", got, err)
+	}
+}
@@ -0,0 +1,92 @@
+package msglib
+
+import (
+	"reflect"
+	"runtime"
+)
+
+// MessageInfo describes how a struct type is encoded, it is shared and must not be modified
+type MessageInfo struct {
+	Type   reflect.Type
+	Name   string      // go type name with package, e.g. msglib.MsgPlayer
+	Fields []FieldInfo // in ascending id order, the order they are encoded
+	// Unknown is the index of the []byte field tagged `msglib:"unknown"`, nil without
+	Unknown []int
+}
+
+// FieldInfo describes a field of a struct type, as read from its msglib tag
+type FieldInfo struct {
+	Name     string       // go field name
+	ID       int          // msglib field id
+	Type     byte         // MT_* wire type
+	GoType   reflect.Type // go type of the field
+	Index    []int        // index for reflect.Value.FieldByIndex, longer than one for fields promoted from embedded structs
+	KeyType  byte         // key type of MT_MAP, MT_NULL otherwise
+	ElemType byte         // element type of MT_LIST and MT_SET, value type of MT_MAP, MT_NULL otherwise
+	EmitZero bool         // zero values are written, set by emitzero and required
+	Required bool
+	Default  interface{} // default value of the go type, nil without default
+}
+
+// Field returns the field with id
+func (info *MessageInfo) Field(id int) (*FieldInfo, bool) {
+	for i := range info.Fields {
+		if info.Fields[i].ID == id {
+			return &info.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// TypeInfo returns the descriptor of struct type t, or of the struct t points to.
+// It is the same field analysis EncodeStruct and DecodeStruct use, so tools can
+// export schemas or validate types up front, getting tag errors like DuplicateFieldError.
+func TypeInfo(t reflect.Type) (info *MessageInfo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = r.(error)
+		}
+	}()
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, &UnsupportedTypeError{Type: t}
+	}
+	return encodeFields(t).info, nil
+}
+
+// containerTypes returns the key and element wire types of list, set and map type t
+func containerTypes(t reflect.Type, msgtype byte) (key, elem byte) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	key, elem = MT_NULL, MT_NULL
+	switch msgtype {
+	case MT_LIST, MT_SET:
+		if t.Kind() == reflect.Map {
+			elem = elemFieldType(t.Key())
+		} else if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			elem = elemFieldType(t.Elem())
+		}
+	case MT_MAP:
+		if t.Kind() == reflect.Map {
+			key, elem = elemFieldType(t.Key()), elemFieldType(t.Elem())
+		}
+	}
+	return
+}
+
+// elemFieldType is fieldType returning MT_NULL for types which can not be encoded,
+// those fail when a value is encoded rather than when the struct is analyzed
+func elemFieldType(t reflect.Type) (msgtype byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			msgtype = MT_NULL
+		}
+	}()
+	return fieldType(t)
+}
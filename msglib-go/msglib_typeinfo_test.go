@@ -0,0 +1,70 @@
+package msglib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTypeInfo(t *testing.T) {
+	info, err := TypeInfo(reflect.TypeOf(&msgTestEmbedded{}))
+	if err != nil {
+		t.Fatalf("type info failure: %+v", err)
+	}
+	// fields of the unexported embedded pointer are promoted
+	if info.Name != "msglib.msgTestEmbedded" || len(info.Fields) != 4 {
+		t.Fatalf("data err, expect 4 fields of msglib.msgTestEmbedded, got %+v", info)
+	}
+	ids := []int{1, 2, 3, 10}
+	names := []string{"Command", "Seq", "Name", "TraceID"}
+	for i, f := range info.Fields {
+		if f.ID != ids[i] || f.Name != names[i] {
+			t.Fatalf("data err, expect %v %v, got %v %v", ids[i], names[i], f.ID, f.Name)
+		}
+	}
+	if f := info.Fields[1]; f.Type != MT_I32 || !reflect.DeepEqual(f.Index, []int{0, 1}) {
+		t.Fatalf("data err, expect promoted i32 field, got %+v", f)
+	}
+	if f := info.Fields[3]; !reflect.DeepEqual(f.Index, []int{1, 0}) {
+		t.Fatalf("data err, expect field of embedded pointer, got %+v", f)
+	}
+
+	// container types, options and defaults
+	info, err = TypeInfo(reflect.TypeOf(msgTestMaps{}))
+	if err != nil {
+		t.Fatalf("type info failure: %+v", err)
+	}
+	if f, ok := info.Field(2); !ok || f.Type != MT_MAP || f.KeyType != MT_I64 || f.ElemType != MT_STRING {
+		t.Fatalf("data err, expect map of i64 to string, got %+v", f)
+	}
+	if f, ok := info.Field(6); !ok || f.Type != MT_SET || f.KeyType != MT_NULL || f.ElemType != MT_I16 {
+		t.Fatalf("data err, expect set of i16, got %+v", f)
+	}
+	info, err = TypeInfo(reflect.TypeOf(msgTestDefault{}))
+	if err != nil {
+		t.Fatalf("type info failure: %+v", err)
+	}
+	if f, _ := info.Field(2); f.Default != "en,us" || f.EmitZero {
+		t.Fatalf("data err, expect default en,us, got %+v", f)
+	}
+	if f, _ := info.Field(5); f.Default != uint8(16) || !f.EmitZero {
+		t.Fatalf("data err, expect default 16 with emitzero, got %+v", f)
+	}
+	info, err = TypeInfo(reflect.TypeOf(msgTestRequired{}))
+	if err != nil {
+		t.Fatalf("type info failure: %+v", err)
+	}
+	if f, _ := info.Field(3); !f.Required || !f.EmitZero || f.Type != MT_STRUCT {
+		t.Fatalf("data err, expect required struct, got %+v", f)
+	}
+	if _, ok := info.Field(5); ok {
+		t.Fatalf("data err, expect no field 5")
+	}
+
+	// errors
+	if _, err = TypeInfo(reflect.TypeOf(&msgTestEmbeddedDup{})); err == nil {
+		t.Fatalf("expect duplicate field error")
+	}
+	if _, err = TypeInfo(reflect.TypeOf(1)); err == nil {
+		t.Fatalf("expect unsupported type error")
+	}
+}
@@ -0,0 +1,83 @@
+package msglib
+
+import (
+	"reflect"
+)
+
+// A []byte field tagged `msglib:"unknown"` keeps fields the struct does not declare:
+//
+//	type MsgPlayer struct {
+//		ID      int32  `msglib:"1"`
+//		Unknown []byte `msglib:"unknown"`
+//	}
+//
+// DecodeStruct stores each unknown field there instead of skipping it, and EncodeStruct
+// writes them back after the declared fields, so a proxy with an older schema passes
+// fields of newer peers through. The bytes are the binary encoding of the fields,
+// whatever proto the message was read with, and are replaced by every decode.
+
+// readUnknown appends the unknown field to the bytes of field
+func (dec *decoder) readUnknown(mfield MField, field reflect.Value) error {
+	writer := &appendWriter{buf: field.Bytes()}
+	bin := NewBinaryProto()
+	if err := bin.WriteFieldBegin(writer, &mfield); err != nil {
+		return err
+	}
+	if r, ok := dec.reader.(*sliceReader); ok && isBinaryProto(dec.proto) {
+		start := r.off
+		if err := skipValue(r, dec.proto, mfield.Type, dec.opts, dec.depth); err != nil {
+			return err
+		}
+		writer.buf = append(writer.buf, r.buf[start:r.off]...)
+	} else {
+		v, err := readAnyValue(dec.reader, dec.proto, mfield.Type, dec.opts, dec.depth)
+		if err != nil {
+			return err
+		}
+		if err = EncodeValue(writer, bin, v); err != nil {
+			return err
+		}
+	}
+	field.SetBytes(writer.buf)
+	return nil
+}
+
+// writeUnknown writes fields kept by readUnknown
+func (enc *encoder) writeUnknown(raw []byte) error {
+	if isBinaryProto(enc.proto) {
+		_, err := enc.writer.Write(raw)
+		return err
+	}
+	reader := newSliceReader(raw)
+	bin := NewBinaryProto()
+	for reader.Len() > 0 {
+		mfield, err := readFieldMarker(bin, reader)
+		if err == nil {
+			var v Value
+			if v, err = readAnyValue(reader, bin, mfield.Type, nil, 0); err == nil {
+				if err = enc.proto.WriteFieldBegin(enc.writer, &mfield); err == nil {
+					err = EncodeValue(enc.writer, enc.proto, v)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isBinaryProto(proto IMProto) bool {
+	_, ok := proto.(*mBinaryProto)
+	return ok
+}
+
+// checkUnknownField panics unless f can keep unknown fields
+func checkUnknownField(root reflect.Type, f reflect.StructField, prev []int) {
+	if f.Type.Kind() != reflect.Slice || f.Type.Elem().Kind() != reflect.Uint8 {
+		panic(&UnsupportedValueError{Message: "unknown fields of " + root.String() + " need a []byte field, got " + f.Type.String()})
+	}
+	if prev != nil {
+		panic(&UnsupportedValueError{Message: "more than one field for unknown fields in " + root.String()})
+	}
+}
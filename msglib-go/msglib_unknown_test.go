@@ -0,0 +1,75 @@
+package msglib
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// msgTestOld is msgTest1 of an older schema, with Name only
+type msgTestOld struct {
+	Name    string `msglib:"1"`
+	Unknown []byte `msglib:"unknown"`
+}
+
+func TestMsglibUnknownFields(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: 23}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte{0xab}}
+	obj.TagList = []*msgTest1_Tag{{Val: 1}}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+
+	var old msgTestOld
+	if err = Deserialize(payload, &old); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if old.Name != "xixi" || len(old.Unknown) == 0 {
+		t.Fatalf("data err, expect name and unknown fields, got %+v", old)
+	}
+	res, err := Serialize(&old)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if !bytes.Equal(res, payload) {
+		t.Fatalf("data err, expect %v, got %v", payload, res)
+	}
+
+	// the text proto re-encodes kept fields
+	old.Name = "haha"
+	buff := &bytes.Buffer{}
+	if err = EncodeStruct(buff, NewTextProto(), &old); err != nil {
+		t.Fatalf("encode object failure: %+v", err)
+	}
+	var text msgTestOld
+	if err = DecodeStruct(bytes.NewReader(buff.Bytes()), NewTextProto(), &text); err != nil {
+		t.Fatalf("decode object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(text, old) {
+		t.Fatalf("data err, expect %+v, got %+v", old, text)
+	}
+	var decoded msgTest1
+	if err = DecodeStruct(bytes.NewReader(buff.Bytes()), NewTextProto(), &decoded); err != nil {
+		t.Fatalf("decode object failure: %+v", err)
+	}
+	obj.Name = "haha"
+	if !reflect.DeepEqual(&decoded, obj) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, &decoded)
+	}
+
+	// every decode replaces kept fields
+	if err = Deserialize([]byte{1<<4 | MT_STRING, 1, 'a', MT_NULL}, &old); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if old.Unknown != nil {
+		t.Fatalf("data err, expect no unknown fields, got %v", old.Unknown)
+	}
+
+	type msgTestBadUnknown struct {
+		Unknown string `msglib:"unknown"`
+	}
+	if _, err = Serialize(&msgTestBadUnknown{}); err == nil {
+		t.Fatalf("expect error for unknown fields in a string")
+	}
+}
@@ -0,0 +1,314 @@
+package msglib
+
+import (
+	"encoding/hex"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value holds any msglib value without a go type, the fields used depend on Type.
+// It lets proxies and tools inspect and re-emit messages they have no structs for.
+type Value struct {
+	Type byte // MT_* type
+
+	Bool  bool    // MT_BOOL
+	Int   int64   // MT_BYTE, MT_I16, MT_I32, MT_I64, bytes are signed
+	Float float64 // MT_FLOAT, MT_DOUBLE
+	Bytes []byte  // MT_BINARY
+	Str   string  // MT_STRING
+
+	Fields []FieldValue // MT_STRUCT, in wire order
+
+	ElemType byte    // element type of MT_LIST and MT_SET, key type of MT_MAP
+	ValType  byte    // value type of MT_MAP
+	Elems    []Value // MT_LIST and MT_SET elements
+	Entries  []Entry // MT_MAP entries, in wire order
+}
+
+// FieldValue is a struct field of a Value
+type FieldValue struct {
+	ID    int
+	Value Value
+}
+
+// Entry is a map entry of a Value
+type Entry struct {
+	Key   Value
+	Value Value
+}
+
+// Field returns the struct field with id
+func (v *Value) Field(id int) (Value, bool) {
+	for _, f := range v.Fields {
+		if f.ID == id {
+			return f.Value, true
+		}
+	}
+	return Value{}, false
+}
+
+// DecodeValue reads a message like DecodeStruct, without knowing its go type
+func DecodeValue(reader io.Reader, proto IMProto) (Value, error) {
+	return decodeValue(reader, proto, nil)
+}
+
+// DecodeValue is DecodeValue enforcing limits of opts
+func (opts *DecodeOptions) DecodeValue(reader io.Reader, proto IMProto) (Value, error) {
+	return decodeValue(reader, proto, opts)
+}
+
+func decodeValue(reader io.Reader, proto IMProto, opts *DecodeOptions) (Value, error) {
+	reader = opts.limitReader(reader)
+	setProtoOptions(proto, opts)
+	defer setProtoOptions(proto, nil)
+	return readAnyValue(reader, proto, MT_STRUCT, opts, 0)
+}
+
+func readAnyValue(reader io.Reader, proto IMProto, msgtype byte, opts *DecodeOptions, depth int) (v Value, err error) {
+	v.Type = msgtype
+	switch msgtype {
+	case MT_STRUCT, MT_MAP, MT_LIST, MT_SET:
+		depth++
+		if err = opts.checkDepth(depth); err != nil {
+			return
+		}
+	}
+	switch msgtype {
+	case MT_BOOL:
+		v.Bool, err = proto.ReadBool(reader)
+	case MT_BYTE:
+		var b byte
+		b, err = proto.ReadByte(reader)
+		v.Int = int64(int8(b))
+	case MT_I16:
+		var n int16
+		n, err = proto.ReadI16(reader)
+		v.Int = int64(n)
+	case MT_I32:
+		var n int32
+		n, err = proto.ReadI32(reader)
+		v.Int = int64(n)
+	case MT_I64:
+		v.Int, err = proto.ReadI64(reader)
+	case MT_FLOAT:
+		var f float32
+		f, err = proto.ReadFloat32(reader)
+		v.Float = float64(f)
+	case MT_DOUBLE:
+		v.Float, err = proto.ReadFloat64(reader)
+	case MT_BINARY:
+		if v.Bytes, err = proto.ReadBinary(reader); err == nil {
+			err = opts.checkLength(len(v.Bytes))
+		}
+	case MT_STRING:
+		if v.Str, err = proto.ReadString(reader); err == nil {
+			err = opts.checkLength(len(v.Str))
+		}
+	case MT_STRUCT:
+		if _, err = proto.ReadStructBegin(reader); err != nil {
+			return
+		}
+		for {
+			var mfield MField
+			if mfield, err = readFieldMarker(proto, reader); err != nil {
+				return
+			}
+			if mfield.Type == MT_NULL {
+				break
+			}
+			f := FieldValue{ID: mfield.ID}
+			if f.Value, err = readAnyValue(reader, proto, mfield.Type, opts, depth); err != nil {
+				return
+			}
+			v.Fields = append(v.Fields, f)
+		}
+	case MT_MAP:
+		var mmap MMap
+		if mmap, err = readMapMarker(proto, reader); err != nil {
+			return
+		}
+		if err = opts.checkCount(mmap.Count); err != nil {
+			return
+		}
+		v.ElemType, v.ValType = mmap.KeyType, mmap.ValueType
+		for i := 0; i < mmap.Count; i++ {
+			var e Entry
+			if e.Key, err = readAnyValue(reader, proto, mmap.KeyType, opts, depth); err != nil {
+				return
+			}
+			if e.Value, err = readAnyValue(reader, proto, mmap.ValueType, opts, depth); err != nil {
+				return
+			}
+			v.Entries = append(v.Entries, e)
+		}
+	case MT_LIST, MT_SET:
+		var mlist MList
+		if msgtype == MT_LIST {
+			mlist, err = readListMarker(proto, reader)
+		} else {
+			var mset MSet
+			mset, err = readSetMarker(proto, reader)
+			mlist = MList{ElementType: mset.ElementType, Count: mset.Count}
+		}
+		if err != nil {
+			return
+		}
+		if err = opts.checkCount(mlist.Count); err != nil {
+			return
+		}
+		v.ElemType = mlist.ElementType
+		for i := 0; i < mlist.Count; i++ {
+			var e Value
+			if e, err = readAnyValue(reader, proto, mlist.ElementType, opts, depth); err != nil {
+				return
+			}
+			v.Elems = append(v.Elems, e)
+		}
+	default:
+		err = &UnsupportedValueError{Message: "unknown type " + strconv.Itoa(int(msgtype))}
+	}
+	return
+}
+
+// EncodeValue writes v, a Value of type MT_STRUCT is written as a message like EncodeStruct does
+func EncodeValue(writer io.Writer, proto IMProto, v Value) error {
+	switch v.Type {
+	case MT_BOOL:
+		return proto.WriteBool(writer, v.Bool)
+	case MT_BYTE:
+		return proto.WriteByte(writer, byte(v.Int))
+	case MT_I16:
+		if v.Int < math.MinInt16 || v.Int > math.MaxInt16 {
+			return &OverflowError{Value: v.Int, Type: typeNames[MT_I16]}
+		}
+		return proto.WriteI16(writer, int16(v.Int))
+	case MT_I32:
+		if v.Int < math.MinInt32 || v.Int > math.MaxInt32 {
+			return &OverflowError{Value: v.Int, Type: typeNames[MT_I32]}
+		}
+		return proto.WriteI32(writer, int32(v.Int))
+	case MT_I64:
+		return proto.WriteI64(writer, v.Int)
+	case MT_FLOAT:
+		return proto.WriteFloat32(writer, float32(v.Float))
+	case MT_DOUBLE:
+		return proto.WriteFloat64(writer, v.Float)
+	case MT_BINARY:
+		return proto.WriteBinary(writer, v.Bytes)
+	case MT_STRING:
+		return proto.WriteString(writer, v.Str)
+	case MT_STRUCT:
+		if err := proto.WriteStructBegin(writer, &MStruct{}); err != nil {
+			return err
+		}
+		for _, f := range v.Fields {
+			if err := proto.WriteFieldBegin(writer, &MField{Type: f.Value.Type, ID: f.ID}); err != nil {
+				return err
+			}
+			if err := EncodeValue(writer, proto, f.Value); err != nil {
+				return err
+			}
+		}
+		return proto.WriteFieldStop(writer)
+	case MT_MAP:
+		mmap := &MMap{KeyType: v.ElemType, ValueType: v.ValType, Count: len(v.Entries)}
+		if err := proto.WriteMapBegin(writer, mmap); err != nil {
+			return err
+		}
+		for _, e := range v.Entries {
+			if e.Key.Type != v.ElemType || e.Value.Type != v.ValType {
+				return &UnsupportedValueError{Message: "map entry type mismatch"}
+			}
+			if err := EncodeValue(writer, proto, e.Key); err != nil {
+				return err
+			}
+			if err := EncodeValue(writer, proto, e.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case MT_LIST, MT_SET:
+		var err error
+		if v.Type == MT_LIST {
+			err = proto.WriteListBegin(writer, &MList{ElementType: v.ElemType, Count: len(v.Elems)})
+		} else {
+			err = proto.WriteSetBegin(writer, &MSet{ElementType: v.ElemType, Count: len(v.Elems)})
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range v.Elems {
+			if e.Type != v.ElemType {
+				return &UnsupportedValueError{Message: "element type mismatch"}
+			}
+			if err := EncodeValue(writer, proto, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return &UnsupportedValueError{Message: "unknown type " + strconv.Itoa(int(v.Type))}
+}
+
+// String returns a readable form of v for logs, e.g. {1: "xixi", 2: [1, 2]}
+func (v Value) String() string {
+	sb := &strings.Builder{}
+	v.format(sb)
+	return sb.String()
+}
+
+func (v Value) format(sb *strings.Builder) {
+	switch v.Type {
+	case MT_BOOL:
+		sb.WriteString(strconv.FormatBool(v.Bool))
+	case MT_BYTE, MT_I16, MT_I32, MT_I64:
+		sb.WriteString(strconv.FormatInt(v.Int, 10))
+	case MT_FLOAT:
+		sb.WriteString(strconv.FormatFloat(v.Float, 'g', -1, 32))
+	case MT_DOUBLE:
+		sb.WriteString(strconv.FormatFloat(v.Float, 'g', -1, 64))
+	case MT_BINARY:
+		sb.WriteString("0x")
+		sb.WriteString(hex.EncodeToString(v.Bytes))
+	case MT_STRING:
+		sb.WriteString(strconv.Quote(v.Str))
+	case MT_STRUCT:
+		sb.WriteByte('{')
+		for i, f := range v.Fields {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(strconv.Itoa(f.ID))
+			sb.WriteString(": ")
+			f.Value.format(sb)
+		}
+		sb.WriteByte('}')
+	case MT_MAP:
+		sb.WriteString("map[")
+		for i, e := range v.Entries {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			e.Key.format(sb)
+			sb.WriteString(": ")
+			e.Value.format(sb)
+		}
+		sb.WriteByte(']')
+	case MT_LIST, MT_SET:
+		if v.Type == MT_SET {
+			sb.WriteString("set")
+		}
+		sb.WriteByte('[')
+		for i, e := range v.Elems {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			e.format(sb)
+		}
+		sb.WriteByte(']')
+	default:
+		sb.WriteString("<unknown type " + strconv.Itoa(int(v.Type)) + ">")
+	}
+}
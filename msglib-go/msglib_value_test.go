@@ -0,0 +1,67 @@
+package msglib
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeValue(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: -3}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: []byte{0xab}}
+	obj.TagList = []*msgTest1_Tag{{Val: 1}}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+
+	val, err := DecodeValue(bytes.NewReader(payload), NewBinaryProto())
+	if err != nil {
+		t.Fatalf("decode value failure: %+v", err)
+	}
+	expect := `{1: "xixi", 2: -3, 3: {1: 0xab, 2: 242}, 4: [{2: 1}]}`
+	if val.String() != expect {
+		t.Fatalf("data err, expect %v, got %v", expect, val)
+	}
+	if name, ok := val.Field(1); !ok || name.Str != "xixi" {
+		t.Fatalf("data err, expect field 1, got %v", name)
+	}
+
+	// re-emit the same bytes
+	buff := &bytes.Buffer{}
+	if err = EncodeValue(buff, NewBinaryProto(), val); err != nil {
+		t.Fatalf("encode value failure: %+v", err)
+	}
+	if !bytes.Equal(buff.Bytes(), payload) {
+		t.Fatalf("data err, expect %v, got %v", payload, buff.Bytes())
+	}
+
+	// maps, sets and the text proto
+	maps := &msgTestMaps{
+		Names:   map[string]int32{"a": 1},
+		Weights: map[float64]int32{0.5: 2},
+		Members: map[uint16]struct{}{7: {}},
+	}
+	buff.Reset()
+	if err = EncodeStruct(buff, NewTextProto(), maps); err != nil {
+		t.Fatalf("encode object failure: %+v", err)
+	}
+	val, err = DecodeValue(bytes.NewReader(buff.Bytes()), NewTextProto())
+	if err != nil {
+		t.Fatalf("decode value failure: %+v", err)
+	}
+	expect = `{1: map["a": 1], 4: map[0.5: 2], 6: set[7]}`
+	if val.String() != expect {
+		t.Fatalf("data err, expect %v, got %v", expect, val)
+	}
+	text := buff.String()
+	buff.Reset()
+	if err = EncodeValue(buff, NewTextProto(), val); err != nil || buff.String() != text {
+		t.Fatalf("data err, expect %v, got %v, err = %v", text, buff.String(), err)
+	}
+
+	// limits
+	opts := &DecodeOptions{MaxDepth: 1}
+	if _, err = opts.DecodeValue(bytes.NewReader(payload), NewBinaryProto()); !isLimitError(err, "MaxDepth") {
+		t.Fatalf("expect MaxDepth error, got %v", err)
+	}
+}
@@ -31,3 +31,23 @@ Example:
 	msglib.MsgCodec.Send(ws, data)
 */
 var MsgCodec = websocket.Codec{msglibMarshal, msglibUnmarshal}
+
+// NewMsgCodec returns a codec like MsgCodec, enforcing limits of opts on received messages.
+// MaxBytes limits a compressed message both before and after decompression.
+func NewMsgCodec(opts *DecodeOptions) websocket.Codec {
+	return NewMsgCodecOptions(nil, opts)
+}
+
+// NewMsgCodecOptions returns a codec like MsgCodec, sending messages with options of encodeOpts,
+// e.g. compressed, and enforcing limits of decodeOpts on received messages.
+// Every codec decompresses received messages.
+func NewMsgCodecOptions(encodeOpts *EncodeOptions, decodeOpts *DecodeOptions) websocket.Codec {
+	marshal := func(v interface{}) (msg []byte, payloadType byte, err error) {
+		msg, err = encodeOpts.Serialize(v)
+		return msg, websocket.BinaryFrame, err
+	}
+	unmarshal := func(msg []byte, payloadType byte, v interface{}) (err error) {
+		return decodeOpts.Deserialize(msg, v)
+	}
+	return websocket.Codec{Marshal: marshal, Unmarshal: unmarshal}
+}
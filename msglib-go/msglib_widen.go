@@ -0,0 +1,61 @@
+package msglib
+
+import (
+	"reflect"
+)
+
+// compatibleType reports whether a value of wire type wire may be decoded into a
+// field declared as local, so that fields can change type between schema versions:
+//
+//	MT_BYTE, MT_I16, MT_I32, MT_I64  any of them, failing with OverflowError when the value does not fit the go type
+//	MT_FLOAT to MT_DOUBLE
+//	MT_STRING and MT_BINARY          either way
+//	MT_LIST and MT_SET               either way, elements follow the same rules
+//
+// Other types must match exactly, as they always do with DecodeOptions.Strict.
+func compatibleType(wire, local byte) bool {
+	if wire == local {
+		return true
+	}
+	switch local {
+	case MT_BYTE, MT_I16, MT_I32, MT_I64:
+		return wire == MT_BYTE || wire == MT_I16 || wire == MT_I32 || wire == MT_I64
+	case MT_DOUBLE:
+		return wire == MT_FLOAT
+	case MT_STRING:
+		return wire == MT_BINARY
+	case MT_BINARY:
+		return wire == MT_STRING
+	case MT_LIST:
+		return wire == MT_SET
+	case MT_SET:
+		return wire == MT_LIST
+	}
+	return false
+}
+
+func (opts *DecodeOptions) strict() bool {
+	return opts != nil && opts.Strict
+}
+
+// compatible reports whether wire type wire is decoded into a value of type local
+func (dec *decoder) compatible(wire, local byte) bool {
+	if dec.opts.strict() {
+		return wire == local
+	}
+	return compatibleType(wire, local)
+}
+
+// checkElemType fails when elements of wire type wire can not be decoded into elements
+// of container, of type local as given by elemFieldType
+func (dec *decoder) checkElemType(wire, local byte, container reflect.Value) error {
+	if local == MT_NULL {
+		// interfaces and types the decoder rejects itself
+		return nil
+	}
+	if !dec.compatible(wire, local) {
+		msg := "type mismatch: " + container.Type().String() + ", element: " + typeNames[wire]
+		return dec.mismatch(local, wire, &UnsupportedValueError{Value: container, Message: msg})
+	}
+	return nil
+}
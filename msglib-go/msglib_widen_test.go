@@ -0,0 +1,92 @@
+package msglib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type msgTestNarrow struct {
+	Count   int32    `msglib:"1"`
+	Speed   float32  `msglib:"2"`
+	Name    []byte   `msglib:"3"`
+	Tags    []int16  `msglib:"4"`
+	Members []uint16 `msglib:"5"`
+	Level   int8     `msglib:"6"`
+}
+
+type msgTestWide struct {
+	Count   int64               `msglib:"1"`
+	Speed   float64             `msglib:"2"`
+	Name    string              `msglib:"3"`
+	Tags    []int64             `msglib:"4"`
+	Members map[uint16]struct{} `msglib:"5"`
+	Level   int32               `msglib:"6"`
+}
+
+func TestMsglibWidening(t *testing.T) {
+	narrow := &msgTestNarrow{Count: -7, Speed: 1.5, Name: []byte("xixi"), Tags: []int16{1, -2}, Members: []uint16{3}, Level: -1}
+	payload, err := Serialize(narrow)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var wide msgTestWide
+	if err = Deserialize(payload, &wide); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	expect := msgTestWide{Count: -7, Speed: 1.5, Name: "xixi", Tags: []int64{1, -2}, Members: map[uint16]struct{}{3: {}}, Level: -1}
+	if !reflect.DeepEqual(wide, expect) {
+		t.Fatalf("data err, expect %+v, got %+v", expect, wide)
+	}
+
+	// and back, with range checks, doubles do not narrow to floats
+	expect.Speed = 0
+	payload, err = Serialize(&expect)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var back msgTestNarrow
+	if err = Deserialize(payload, &back); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if back.Count != -7 || string(back.Name) != "xixi" || !reflect.DeepEqual(back.Tags, narrow.Tags) {
+		t.Fatalf("data err, expect %+v, got %+v", narrow, back)
+	}
+	payload, err = Serialize(&msgTestWide{Count: 1 << 40})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if err = Deserialize(payload, &back); err == nil {
+		t.Fatalf("expect overflow of int32")
+	} else if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect OverflowError, got %+v", err)
+	}
+
+	// strict keeps exact types
+	payload, err = Serialize(&msgTestNarrow{Count: 1})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if err = (&DecodeOptions{Strict: true}).Deserialize(payload, &wide); err == nil {
+		t.Fatalf("expect type mismatch in strict mode")
+	}
+	payload, err = Serialize(&msgTestWide{Tags: []int64{1}})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if err = (&DecodeOptions{Strict: true}).Deserialize(payload, &back); err == nil {
+		t.Fatalf("expect element type mismatch in strict mode")
+	}
+
+	// incompatible elements
+	type msgTestStrings struct {
+		Tags []string `msglib:"4"`
+	}
+	payload, err = Serialize(&msgTestStrings{Tags: []string{"a"}})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if err = Deserialize(payload, &wide); err == nil {
+		t.Fatalf("expect element type mismatch")
+	}
+}
@@ -0,0 +1,183 @@
+// Package rpc implements net/rpc ServerCodec and ClientCodec with msglib binary encoding.
+//
+// Requests and responses are written as a header message followed by a body message,
+// each one framed by msglib.Encoder. Arguments and replies must be msglib structs.
+package rpc
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/rpc"
+
+	msglib "github.com/xuwaters/msglib/msglib-go"
+)
+
+type requestHeader struct {
+	ServiceMethod string `msglib:"1"`
+	Seq           uint64 `msglib:"2"`
+}
+
+type responseHeader struct {
+	ServiceMethod string `msglib:"1"`
+	Seq           uint64 `msglib:"2"`
+	Error         string `msglib:"3"`
+}
+
+// DefaultMaxBytes limits messages read by codecs created without DecodeOptions,
+// peers of a connection are not trusted.
+const DefaultMaxBytes = 4 << 20
+
+// discard is decoded into when the body is not wanted, all fields are skipped
+type discard struct{}
+
+type codec struct {
+	rwc    io.ReadWriteCloser
+	dec    *msglib.Decoder
+	enc    *msglib.Encoder
+	encBuf *bufio.Writer
+}
+
+func newCodec(conn io.ReadWriteCloser, opts *msglib.DecodeOptions) codec {
+	if opts == nil {
+		opts = &msglib.DecodeOptions{MaxBytes: DefaultMaxBytes}
+	}
+	buf := bufio.NewWriter(conn)
+	dec := msglib.NewDecoder(conn)
+	dec.SetOptions(opts)
+	return codec{
+		rwc:    conn,
+		dec:    dec,
+		enc:    msglib.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *codec) write(header interface{}, body interface{}) (err error) {
+	if err = c.enc.Encode(header); err != nil {
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *codec) readBody(body interface{}) error {
+	if body == nil {
+		body = &discard{}
+	}
+	return c.dec.Decode(body)
+}
+
+// server
+
+type serverCodec struct {
+	codec
+}
+
+// NewServerCodec returns a ServerCodec reading messages of DefaultMaxBytes at most
+func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return NewServerCodecOptions(conn, nil)
+}
+
+// NewServerCodecOptions returns a ServerCodec enforcing limits of opts on requests,
+// nil opts means MaxBytes of DefaultMaxBytes, &msglib.DecodeOptions{} no limits at all.
+func NewServerCodecOptions(conn io.ReadWriteCloser, opts *msglib.DecodeOptions) rpc.ServerCodec {
+	return &serverCodec{codec: newCodec(conn, opts)}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	var header requestHeader
+	if err := c.dec.Decode(&header); err != nil {
+		return err
+	}
+	r.ServiceMethod = header.ServiceMethod
+	r.Seq = header.Seq
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	header := &responseHeader{
+		ServiceMethod: r.ServiceMethod,
+		Seq:           r.Seq,
+		Error:         r.Error,
+	}
+	if err := c.write(header, body); err != nil {
+		// the stream is out of sync after a partial response
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *serverCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// ServeConn runs the DefaultServer on a single connection, blocking until the client hangs up.
+func ServeConn(conn io.ReadWriteCloser) {
+	rpc.ServeCodec(NewServerCodec(conn))
+}
+
+// client
+
+type clientCodec struct {
+	codec
+}
+
+// NewClientCodec returns a ClientCodec reading messages of DefaultMaxBytes at most
+func NewClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return NewClientCodecOptions(conn, nil)
+}
+
+// NewClientCodecOptions returns a ClientCodec enforcing limits of opts on responses,
+// nil opts means MaxBytes of DefaultMaxBytes, &msglib.DecodeOptions{} no limits at all.
+func NewClientCodecOptions(conn io.ReadWriteCloser, opts *msglib.DecodeOptions) rpc.ClientCodec {
+	return &clientCodec{codec: newCodec(conn, opts)}
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	header := &requestHeader{
+		ServiceMethod: r.ServiceMethod,
+		Seq:           r.Seq,
+	}
+	return c.write(header, body)
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	var header responseHeader
+	if err := c.dec.Decode(&header); err != nil {
+		return err
+	}
+	r.ServiceMethod = header.ServiceMethod
+	r.Seq = header.Seq
+	r.Error = header.Error
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// NewClient returns a new rpc.Client to handle requests to the set of services at the other end of the connection.
+func NewClient(conn io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn))
+}
+
+// Dial connects to a msglib rpc server at the specified network address.
+func Dial(network, address string) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+
+	msglib "github.com/xuwaters/msglib/msglib-go"
+)
+
+type Args struct {
+	A int32 `msglib:"1"`
+	B int32 `msglib:"2"`
+}
+
+type Reply struct {
+	C int32 `msglib:"1"`
+}
+
+type Arith int
+
+func (t *Arith) Add(args *Args, reply *Reply) error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (t *Arith) Div(args *Args, reply *Reply) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	reply.C = args.A / args.B
+	return nil
+}
+
+func TestRpcCodec(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatalf("register failure: %+v", err)
+	}
+	cli, srv := net.Pipe()
+	go server.ServeCodec(NewServerCodec(srv))
+
+	client := NewClient(cli)
+	defer client.Close()
+
+	for i := int32(0); i < 3; i++ {
+		var reply Reply
+		if err := client.Call("Arith.Add", &Args{A: 7, B: i}, &reply); err != nil {
+			t.Fatalf("call failure: %+v", err)
+		}
+		if reply.C != 7+i {
+			t.Fatalf("data err, expect %v, got %v", 7+i, reply.C)
+		}
+	}
+
+	var reply Reply
+	err := client.Call("Arith.Div", &Args{A: 7}, &reply)
+	if err == nil || err.Error() != "divide by zero" {
+		t.Fatalf("expect server error, got %v", err)
+	}
+	err = client.Call("Arith.Unknown", &Args{A: 7}, &reply)
+	if err == nil {
+		t.Fatalf("expect unknown method error")
+	}
+
+	// connection still usable after errors
+	if err := client.Call("Arith.Div", &Args{A: 8, B: 2}, &reply); err != nil || reply.C != 4 {
+		t.Fatalf("call failure or not match: %v, %+v", reply.C, err)
+	}
+}
+
+type bufferConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (bufferConn) Close() error { return nil }
+
+func TestRpcCodecLimits(t *testing.T) {
+	// a hostile length prefix is rejected before anything is allocated
+	hostile := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}
+	codec := NewServerCodec(bufferConn{bytes.NewReader(hostile), io.Discard})
+	var le *msglib.LimitError
+	if err := codec.ReadRequestHeader(&rpc.Request{}); !errors.As(err, &le) || le.Max != DefaultMaxBytes {
+		t.Fatalf("expect MaxBytes of %v exceeded, got %v", DefaultMaxBytes, err)
+	}
+
+	// options replace the default
+	buff := &bytes.Buffer{}
+	client := NewClientCodec(bufferConn{&bytes.Buffer{}, buff})
+	if err := client.WriteRequest(&rpc.Request{ServiceMethod: "Arith.Add", Seq: 1}, &Args{A: 7, B: 2}); err != nil {
+		t.Fatalf("write request failure: %+v", err)
+	}
+	codec = NewServerCodecOptions(bufferConn{buff, io.Discard}, &msglib.DecodeOptions{MaxBytes: 4})
+	if err := codec.ReadRequestHeader(&rpc.Request{}); !errors.As(err, &le) || le.Max != 4 {
+		t.Fatalf("expect MaxBytes of 4 exceeded, got %v", err)
+	}
+}
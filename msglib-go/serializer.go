@@ -1,212 +1,190 @@
 package msglib
 
 import (
-	"bytes"
 	"io"
 	"reflect"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 )
 
 func Serialize(data interface{}) (payload []byte, err error) {
-	writer := &bytes.Buffer{}
-	proto := NewBinaryProto()
-	err = EncodeStruct(writer, proto, data)
+	return AppendSerialize(nil, data)
+}
+
+// AppendSerialize appends the encoding of data to dst and returns the extended buffer.
+// dst is returned unchanged on error. With a dst of capacity len(dst)+Size(data)
+// encoding does not allocate a new buffer.
+func AppendSerialize(dst []byte, data interface{}) ([]byte, error) {
+	return appendSerialize(dst, data, nil)
+}
+
+func appendSerialize(dst []byte, data interface{}, opts *EncodeOptions) ([]byte, error) {
+	writer := &appendWriter{buf: dst}
+	proto := binaryProtoPool.Get().(IMProto)
+	defer binaryProtoPool.Put(proto)
+	if err := encodeStruct(writer, proto, data, opts); err != nil {
+		return dst, err
+	}
+	if !hasEnvelope(data) {
+		return writer.buf, nil
+	}
+	env, ok, err := opts.compress(nil, writer.buf[len(dst):])
 	if err != nil {
-		return
+		return dst, err
 	}
-	payload = writer.Bytes()
-	return
+	if ok {
+		return append(writer.buf[:len(dst)], env...), nil
+	}
+	return writer.buf, nil
 }
 
-func Deserialize(payload []byte, data interface{}) (err error) {
-	reader := bytes.NewBuffer(payload)
-	proto := NewBinaryProto()
-	err = DecodeStruct(reader, proto, data)
-	return
+// Size returns the exact length of the encoding of data written by Serialize
+func Size(data interface{}) (int, error) {
+	writer := &countWriter{}
+	proto := binaryProtoPool.Get().(IMProto)
+	defer binaryProtoPool.Put(proto)
+	if err := EncodeStruct(writer, proto, data); err != nil {
+		return 0, err
+	}
+	return writer.count, nil
 }
 
+// binaryProtoPool keeps binary protos used by Serialize, AppendSerialize and Size
+var binaryProtoPool = sync.Pool{
+	New: func() interface{} { return NewBinaryProto() },
+}
+
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+type countWriter struct {
+	count int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.count += len(p)
+	return len(p), nil
+}
+
+// Deserialize decodes payload written by Serialize into data, opening compressed envelopes
+func Deserialize(payload []byte, data interface{}) error {
+	return (*DecodeOptions)(nil).Deserialize(payload, data)
+}
+
+// Marshaler is implemented by types that write themselves, e.g. code generated by msglibc.
+// MarshalMsglib writes one complete value, for structs including the field stop marker.
+type Marshaler interface {
+	MarshalMsglib(writer io.Writer, proto IMProto) error
+}
+
+// Unmarshaler is implemented by types that read themselves, the reverse of Marshaler.
+type Unmarshaler interface {
+	UnmarshalMsglib(reader io.Reader, proto IMProto) error
+}
+
+// Typer reports the MT_* type a Marshaler writes, when it differs from the type
+// derived from the go kind, e.g. a struct written as MT_STRING.
+// MsglibType is called on zero values and must not depend on the receiver.
+type Typer interface {
+	MsglibType() byte
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	typerType       = reflect.TypeOf((*Typer)(nil)).Elem()
+)
+
 // encode
 
 type encoder struct {
 	writer io.Writer
 	proto  IMProto
+	opts   *EncodeOptions
+	// markers reused by plans, saving an allocation per value
+	mstruct MStruct
+	mfield  MField
+	mmap    MMap
+	mlist   MList
+	mset    MSet
 }
 
 func EncodeStruct(w io.Writer, proto IMProto, data interface{}) (err error) {
-	//
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			err = r.(error)
-		}
-	}()
-	enc := &encoder{writer: w, proto: proto}
-	vo := reflect.ValueOf(data)
-	enc.writeStruct(vo)
-	return nil
+	return encodeStruct(w, proto, data, nil)
 }
 
-func (enc *encoder) error(err interface{}) {
-	panic(err)
+func encodeStruct(w io.Writer, proto IMProto, data interface{}, opts *EncodeOptions) error {
+	enc := &encoder{writer: w, proto: proto, opts: opts}
+	vo := reflect.ValueOf(data)
+	if m, ok := marshalerOf(vo); ok {
+		return m.MarshalMsglib(w, proto)
+	}
+	for vo.Kind() == reflect.Ptr || vo.Kind() == reflect.Interface {
+		vo = vo.Elem()
+	}
+	if vo.Kind() != reflect.Struct {
+		return &UnsupportedValueError{Value: vo, Message: "expect a struct"}
+	}
+	return encodePlanFor(vo.Type(), MT_STRUCT).encode(enc, vo)
 }
 
-func (enc *encoder) writeStruct(val reflect.Value) {
-	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+// marshalerOf returns the Marshaler implemented by val or by a pointer to val
+func marshalerOf(val reflect.Value) (Marshaler, bool) {
+	if val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil, false
+		}
 		val = val.Elem()
 	}
-	if val.Kind() != reflect.Struct {
-		enc.error(&UnsupportedValueError{Value: val, Message: "expect a struct"})
+	if !val.IsValid() {
+		return nil, false
 	}
-	marker := &MStruct{}
-	if err := enc.proto.WriteStructBegin(enc.writer, marker); err != nil {
-		enc.error(err)
+	if val.Type().Implements(marshalerType) {
+		return val.Interface().(Marshaler), true
 	}
-	for _, ef := range encodeFields(val.Type()).fields {
-		field := val.Type().Field(ef.i)
-		fieldValue := val.Field(ef.i)
-
-		if isEmptyValue(fieldValue) {
-			continue
+	if reflect.PtrTo(val.Type()).Implements(marshalerType) {
+		if !val.CanAddr() {
+			ptr := reflect.New(val.Type())
+			ptr.Elem().Set(val)
+			val = ptr.Elem()
 		}
-
-		mfield := &MField{Name: field.Name, Type: ef.fieldType, ID: ef.id}
-		if err := enc.proto.WriteFieldBegin(enc.writer, mfield); err != nil {
-			enc.error(err)
-		}
-		enc.writeValue(fieldValue, ef.fieldType)
+		return val.Addr().Interface().(Marshaler), true
 	}
-	enc.proto.WriteFieldStop(enc.writer)
+	return nil, false
 }
 
-func (enc *encoder) writeValue(val reflect.Value, valtype byte) {
-	kind := val.Kind()
-	if kind == reflect.Ptr || kind == reflect.Interface {
-		val = val.Elem()
-		kind = val.Kind()
+// writeInt checks the range of integer val and writes it as MT_I16, MT_I32 or MT_I64.
+// Unsigned values are written as signed values of the same width.
+func (enc *encoder) writeInt(val reflect.Value, valtype byte) error {
+	bits := intBits(valtype)
+	var n int64
+	if isUintKind(val.Kind()) {
+		u := val.Uint()
+		if bits < 64 && u>>bits != 0 {
+			return &OverflowError{Value: u, Type: typeNames[valtype]}
+		}
+		n = int64(u)
+	} else {
+		n = val.Int()
+		if bits < 64 && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+			return &OverflowError{Value: n, Type: typeNames[valtype]}
+		}
 	}
-
-	var err error = nil
 	switch valtype {
-	case MT_BOOL:
-		err = enc.proto.WriteBool(enc.writer, val.Bool())
-	case MT_BYTE:
-		if kind == reflect.Uint8 {
-			err = enc.proto.WriteByte(enc.writer, byte(val.Uint()))
-		} else {
-			err = enc.proto.WriteByte(enc.writer, byte(val.Int()))
-		}
 	case MT_I16:
-		if kind == reflect.Uint16 {
-			err = enc.proto.WriteI16(enc.writer, int16(val.Uint()))
-		} else {
-			err = enc.proto.WriteI16(enc.writer, int16(val.Int()))
-		}
+		return enc.proto.WriteI16(enc.writer, int16(n))
 	case MT_I32:
-		if kind == reflect.Uint32 {
-			err = enc.proto.WriteI32(enc.writer, int32(val.Uint()))
-		} else {
-			err = enc.proto.WriteI32(enc.writer, int32(val.Int()))
-		}
-	case MT_I64:
-		if kind == reflect.Uint64 {
-			err = enc.proto.WriteI64(enc.writer, int64(val.Uint()))
-		} else {
-			err = enc.proto.WriteI64(enc.writer, int64(val.Int()))
-		}
-	case MT_FLOAT:
-		err = enc.proto.WriteFloat32(enc.writer, float32(val.Float()))
-	case MT_DOUBLE:
-		err = enc.proto.WriteFloat64(enc.writer, val.Float())
-	case MT_BINARY:
-		err = enc.proto.WriteBinary(enc.writer, val.Bytes())
-	case MT_STRING:
-		err = enc.proto.WriteString(enc.writer, val.String())
-	case MT_STRUCT:
-		enc.writeStruct(val)
-	case MT_MAP:
-		keytype := val.Type().Key()
-		valtype := val.Type().Elem()
-
-		mmap := &MMap{}
-		mmap.Count = val.Len()
-		mmap.KeyType = fieldType(keytype)
-		mmap.ValueType = fieldType(valtype)
-		if er := enc.proto.WriteMapBegin(enc.writer, mmap); er != nil {
-			enc.error(er)
-		}
-		for _, k := range val.MapKeys() {
-			enc.writeValue(k, mmap.KeyType)
-			enc.writeValue(val.MapIndex(k), mmap.ValueType)
-		}
-	case MT_LIST:
-		elemtype := val.Type().Elem()
-		if elemtype.Kind() == reflect.Uint8 {
-			err = enc.proto.WriteBinary(enc.writer, val.Bytes())
-		} else {
-			mlist := &MList{}
-			mlist.Count = val.Len()
-			mlist.ElementType = fieldType(elemtype)
-			if er := enc.proto.WriteListBegin(enc.writer, mlist); er != nil {
-				enc.error(er)
-			}
-			for i := 0; i < mlist.Count; i++ {
-				enc.writeValue(val.Index(i), mlist.ElementType)
-			}
-		}
-	case MT_SET:
-		if val.Type().Kind() == reflect.Slice {
-			elemtype := val.Type().Elem()
-			mset := &MSet{}
-			mset.Count = val.Len()
-			mset.ElementType = fieldType(elemtype)
-			if er := enc.proto.WriteSetBegin(enc.writer, mset); er != nil {
-				enc.error(er)
-			}
-			for i := 0; i < mset.Count; i++ {
-				enc.writeValue(val.Index(i), mset.ElementType)
-			}
-		} else if val.Type().Kind() == reflect.Map {
-			elemtype := val.Type().Key()
-			valuetype := val.Type().Elem()
-			mset := &MSet{}
-			mset.ElementType = fieldType(elemtype)
-			if valuetype.Kind() == reflect.Bool {
-				mset.Count = 0
-				for _, k := range val.MapKeys() {
-					if val.MapIndex(k).Bool() {
-						mset.Count++
-					}
-				}
-				if er := enc.proto.WriteSetBegin(enc.writer, mset); er != nil {
-					enc.error(er)
-				}
-				for _, k := range val.MapKeys() {
-					if val.MapIndex(k).Bool() {
-						enc.writeValue(val.MapIndex(k), mset.ElementType)
-					}
-				}
-			} else {
-				if er := enc.proto.WriteSetBegin(enc.writer, mset); er != nil {
-					enc.error(er)
-				}
-				for _, k := range val.MapKeys() {
-					enc.writeValue(k, mset.ElementType)
-				}
-			}
-		} else {
-			enc.error(&UnsupportedTypeError{Type: val.Type()})
-		}
+		return enc.proto.WriteI32(enc.writer, int32(n))
 	default:
-		enc.error(&UnsupportedTypeError{Type: val.Type()})
-	}
-
-	if err != nil {
-		enc.error(err)
+		return enc.proto.WriteI64(enc.writer, n)
 	}
 }
 
@@ -214,245 +192,160 @@ func (enc *encoder) writeValue(val reflect.Value, valtype byte) {
 type decoder struct {
 	reader io.Reader
 	proto  IMProto
+	opts   *DecodeOptions
+	depth  int
+	root   string     // type name of the decoded struct, starts DecodeError.Path
+	path   []pathElem // fields and elements being decoded
 }
 
-func (dec *decoder) error(err interface{}) {
-	panic(err)
+func DecodeStruct(reader io.Reader, proto IMProto, val interface{}) (err error) {
+	return decodeStruct(reader, proto, val, nil)
 }
 
-func DecodeStruct(reader io.Reader, proto IMProto, val interface{}) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			err = r.(error)
-		}
-	}()
+func decodeStruct(reader io.Reader, proto IMProto, val interface{}, opts *DecodeOptions) error {
+	reader = opts.limitReader(reader)
+	if _, ok := reader.(*sliceReader); !ok {
+		reader = newCountingReader(reader)
+	}
+	setProtoOptions(proto, opts)
+	defer setProtoOptions(proto, nil)
+	dec := &decoder{reader: reader, proto: proto, opts: opts}
+	if t := reflect.TypeOf(val); t != nil {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		dec.root = t.Name()
+	}
 
-	dec := &decoder{reader: reader, proto: proto}
+	if u, ok := val.(Unmarshaler); ok {
+		if err := u.UnmarshalMsglib(reader, proto); err != nil {
+			return dec.decodeError(err)
+		}
+		return nil
+	}
 	vo := reflect.ValueOf(val)
-	dec.readStruct(vo)
+	if vo.Kind() != reflect.Ptr {
+		return dec.decodeError(&UnsupportedValueError{Value: vo, Message: "expect pointer to struct"})
+	}
+	if vo.Elem().Kind() != reflect.Struct {
+		return dec.decodeError(&UnsupportedValueError{Value: vo, Message: "expect a struct"})
+	}
+	if err := decodePlanFor(vo.Elem().Type()).decode(dec, MT_STRUCT, vo.Elem()); err != nil {
+		return dec.decodeError(err)
+	}
 	return nil
 }
 
-func (dec *decoder) readStruct(val reflect.Value) {
-	if val.Kind() != reflect.Ptr {
-		dec.error(&UnsupportedValueError{Value: val, Message: "expect pointer to struct"})
+// unmarshalerOf returns the Unmarshaler implemented by val or by a pointer to val,
+// nil pointers are allocated.
+func unmarshalerOf(val reflect.Value) (Unmarshaler, bool) {
+	if val.Kind() == reflect.Ptr && val.Type().Implements(unmarshalerType) {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		return val.Interface().(Unmarshaler), true
 	}
-	if val.Elem().Kind() != reflect.Struct {
-		dec.error(&UnsupportedValueError{Value: val, Message: "expect a struct"})
+	if val.CanAddr() && reflect.PtrTo(val.Type()).Implements(unmarshalerType) {
+		return val.Addr().Interface().(Unmarshaler), true
 	}
-	dec.readValue(MT_STRUCT, val.Elem())
+	return nil, false
 }
 
-func (dec *decoder) readValue(msgtype byte, rfval reflect.Value) {
-	ret := rfval
-	kind := rfval.Kind()
-	if kind == reflect.Ptr {
-		if rfval.IsNil() {
-			rfval.Set(reflect.New(rfval.Type().Elem()))
-		}
-		ret = rfval.Elem()
-		kind = ret.Kind()
+func (dec *decoder) checkArrayLen(val reflect.Value, count int) error {
+	if count != val.Len() {
+		msg := "array length mismatch: expect " + strconv.Itoa(val.Len()) + ", got " + strconv.Itoa(count)
+		return &UnsupportedValueError{Value: val, Message: msg}
 	}
-	//
-	var err error = nil
+	return nil
+}
+
+// readInt reads MT_I16, MT_I32 or MT_I64 into an integer, checking the range of the go type
+func (dec *decoder) readInt(msgtype byte, ret reflect.Value) error {
+	var (
+		n   int64
+		err error
+	)
 	switch msgtype {
-	case MT_BOOL:
-		if val, err := dec.proto.ReadBool(dec.reader); err != nil {
-			dec.error(err)
-		} else {
-			ret.SetBool(val)
-		}
-	case MT_BYTE:
-		if val, err := dec.proto.ReadByte(dec.reader); err != nil {
-			dec.error(err)
-		} else {
-			if kind == reflect.Uint8 {
-				ret.SetUint(uint64(val))
-			} else {
-				ret.SetInt(int64(val))
-			}
-		}
 	case MT_I16:
-		if val, err := dec.proto.ReadI16(dec.reader); err != nil {
-			dec.error(err)
-		} else {
-			if kind == reflect.Uint16 {
-				ret.SetUint(uint64(val))
-			} else {
-				ret.SetInt(int64(val))
-			}
-		}
+		var val int16
+		val, err = dec.proto.ReadI16(dec.reader)
+		n = int64(val)
 	case MT_I32:
-		if val, err := dec.proto.ReadI32(dec.reader); err != nil {
-			dec.error(err)
-		} else {
-			if kind == reflect.Uint32 {
-				ret.SetUint(uint64(val))
-			} else {
-				ret.SetInt(int64(val))
-			}
-		}
-	case MT_I64:
-		if val, err := dec.proto.ReadI64(dec.reader); err != nil {
-			dec.error(err)
-		} else {
-			if kind == reflect.Uint64 {
-				ret.SetUint(uint64(val))
-			} else {
-				ret.SetInt(val)
-			}
-		}
-	case MT_FLOAT:
-		if val, err := dec.proto.ReadFloat32(dec.reader); err != nil {
-			dec.error(err)
-		} else {
-			ret.SetFloat(float64(val))
-		}
-	case MT_DOUBLE:
-		if val, err := dec.proto.ReadFloat64(dec.reader); err != nil {
-			dec.error(err)
-		} else {
-			ret.SetFloat(val)
-		}
-	case MT_BINARY:
-		elemtype := ret.Type().Elem()
-		elemtypename := elemtype.Name()
-		if kind == reflect.Slice && elemtype.Kind() == reflect.Uint8 && (elemtypename == "uint8" || elemtypename == "byte") {
-			if val, err := dec.proto.ReadBinary(dec.reader); err != nil {
-				dec.error(err)
-			} else {
-				ret.SetBytes(val)
-			}
-		} else {
-			err = &UnsupportedValueError{Value: ret, Message: "expect a byte array"}
-		}
-	case MT_STRING:
-		if kind == reflect.Slice {
-			dec.readValue(MT_BINARY, ret)
-		} else {
-			if val, err := dec.proto.ReadString(dec.reader); err != nil {
-				dec.error(err)
-			} else {
-				ret.SetString(val)
-			}
-		}
-
-	case MT_STRUCT:
-		if _, err := dec.proto.ReadStructBegin(dec.reader); err != nil {
-			dec.error(err)
-		}
-
-		meta := encodeFields(ret.Type())
-		for {
-			mfield, err := dec.proto.ReadFieldBegin(dec.reader)
-			if err != nil {
-				dec.error(err)
-			}
-			if mfield.Type == MT_NULL {
-				break
-			}
-
-			ef, ok := meta.fields[int(mfield.ID)]
-			if !ok {
-				SkipValue(dec.reader, dec.proto, mfield.Type)
-			} else {
-				fval := ret.Field(ef.i)
-				if mfield.Type != ef.fieldType {
-					msg := "type mismatch: " + ret.Type().Name() + ", field: " + ef.name
-					dec.error(&UnsupportedValueError{Value: ret, Message: msg})
-				} else {
-					dec.readValue(mfield.Type, fval)
-				}
-			}
-		}
-
-	case MT_MAP:
-		keytype := ret.Type().Key()
-		valtype := ret.Type().Elem()
-		mmap, err := dec.proto.ReadMapBegin(dec.reader)
-		if err != nil {
-			dec.error(err)
-		}
-		ret.Set(reflect.MakeMap(ret.Type()))
-		for i := 0; i < mmap.Count; i++ {
-			key := reflect.New(keytype).Elem()
-			val := reflect.New(valtype).Elem()
-			dec.readValue(mmap.KeyType, key)
-			dec.readValue(mmap.ValueType, val)
-			ret.SetMapIndex(key, val)
+		var val int32
+		val, err = dec.proto.ReadI32(dec.reader)
+		n = int64(val)
+	default:
+		n, err = dec.proto.ReadI64(dec.reader)
+	}
+	if err != nil {
+		return err
+	}
+	if isUintKind(ret.Kind()) {
+		// signed on the wire, see encoder.writeInt
+		u := uint64(n)
+		if bits := intBits(msgtype); bits < 64 {
+			u &= 1<<bits - 1
 		}
-
-	case MT_LIST:
-		elemtype := ret.Type().Elem()
-		mlist, err := dec.proto.ReadListBegin(dec.reader)
-		if err != nil {
-			dec.error(err)
+		if ret.OverflowUint(u) {
+			return &OverflowError{Value: u, Type: ret.Type().String()}
 		}
-		for i := 0; i < mlist.Count; i++ {
-			val := reflect.New(elemtype).Elem()
-			dec.readValue(mlist.ElementType, val)
-			ret.Set(reflect.Append(ret, val))
+		ret.SetUint(u)
+	} else {
+		if ret.OverflowInt(n) {
+			return &OverflowError{Value: n, Type: ret.Type().String()}
 		}
+		ret.SetInt(n)
+	}
+	return nil
+}
 
-	case MT_SET:
-		rettype := ret.Type()
-		if rettype.Kind() == reflect.Slice {
-			elemtype := rettype.Elem()
-			mset, err := dec.proto.ReadSetBegin(dec.reader)
-			if err != nil {
-				dec.error(err)
-			}
-			for i := 0; i < mset.Count; i++ {
-				val := reflect.New(elemtype).Elem()
-				dec.readValue(mset.ElementType, val)
-				ret.Set(reflect.Append(ret, val))
-			}
-		} else if rettype.Kind() == reflect.Map {
-			elemtype := rettype.Key()
-			valtype := rettype.Elem()
-			mset, err := dec.proto.ReadSetBegin(dec.reader)
-			if err != nil {
-				dec.error(err)
-			}
-			ret.Set(reflect.MakeMap(rettype))
-			for i := 0; i < mset.Count; i++ {
-				key := reflect.New(elemtype).Elem()
-				dec.readValue(mset.ElementType, key)
-				switch valtype.Kind() {
-				case reflect.Bool:
-					ret.SetMapIndex(key, reflect.ValueOf(true))
-				default:
-					ret.SetMapIndex(key, reflect.Zero(valtype))
-				}
-			}
-		} else {
-			dec.error(&UnsupportedTypeError{Type: ret.Type()})
-		}
+// helpers
 
-	default:
-		dec.error(&UnsupportedTypeError{Type: ret.Type()})
+func isUintKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
 	}
-	if err != nil {
-		dec.error(err)
+	return false
+}
+
+func intBits(msgtype byte) uint {
+	switch msgtype {
+	case MT_BYTE:
+		return 8
+	case MT_I16:
+		return 16
+	case MT_I32:
+		return 32
 	}
-	return
+	return 64
 }
 
-// helpers
+// bytesOf returns content of a byte slice or a byte array
+func bytesOf(val reflect.Value) []byte {
+	if val.Kind() != reflect.Array {
+		return val.Bytes()
+	}
+	buf := make([]byte, val.Len())
+	reflect.Copy(reflect.ValueOf(buf), val)
+	return buf
+}
 
 // meta analyze
 type encodeField struct {
-	i         int // field index in struct
-	id        int // msglib field id for struct
-	fieldType byte
-	name      string
+	FieldInfo
+	required int // position in structMeta.required, -1 for optional fields
+	// defaultValue is set when a field is absent and not written when equal, invalid without default
+	defaultValue reflect.Value
 }
 
 type structMeta struct {
-	fields map[int]encodeField
+	info     *MessageInfo
+	fields   map[int]encodeField
+	unknown  []int         // index of the field keeping unknown fields, nil without
+	sorted   []encodeField // fields in ascending id order, the order they are encoded
+	defaults []encodeField // fields with a default value
+	required []encodeField // required fields, in ascending id order
 }
 
 var (
@@ -460,6 +353,28 @@ var (
 	encodeFieldsCache = make(map[reflect.Type]structMeta)
 )
 
+// encodeFields analyzes fields of struct type t.
+//
+// Fields of anonymous embedded structs without a msglib tag are promoted into t,
+// sharing the field id space of t, the same way encoding/json promotes them.
+// An embedded struct with a tag like `msglib:"5"` is encoded as a nested MT_STRUCT field instead.
+//
+// Zero numbers, empty strings, zero arrays and nil slices and maps are not written,
+// so they decode as zero. The tag option `msglib:"3,emitzero"` writes them anyway,
+// a blank field _ struct{} tagged `msglib:",emitzero"` does so for all fields of a struct.
+// Nil pointers and interfaces are never written: a pointer to a scalar field is nil
+// after decoding when the field was absent, and points to the value when it was sent,
+// even a zero value.
+//
+// The tag option `msglib:"2,default=100"` gives bool, number and string fields a default:
+// the decoder sets it when the field is absent, and the encoder leaves the field out
+// when it equals the default. The default takes the rest of the tag, e.g.
+// `msglib:"4,emitzero,default=en,us"` has the default "en,us".
+// A struct valued field which is absent is not decoded, its fields keep zero values.
+//
+// The tag option `msglib:"1,required"` implies emitzero. Encoding fails with
+// MissingFieldError when a required pointer or interface is nil, and decoding
+// fails with MissingFieldError when a required field is absent.
 func encodeFields(t reflect.Type) structMeta {
 	typeCacheLock.RLock()
 	m, ok := encodeFieldsCache[t]
@@ -477,40 +392,180 @@ func encodeFields(t reflect.Type) structMeta {
 	}
 
 	fs := make(map[int]encodeField)
-	m = structMeta{fields: fs}
-	v := reflect.Zero(t)
-	n := v.NumField()
+	var unknown []int
+	collectFields(t, t, nil, fs, &unknown, map[reflect.Type]bool{}, false)
+	m = structMeta{fields: fs, unknown: unknown, sorted: make([]encodeField, 0, len(fs))}
+	for _, ef := range fs {
+		m.sorted = append(m.sorted, ef)
+	}
+	sort.Slice(m.sorted, func(i, j int) bool { return m.sorted[i].ID < m.sorted[j].ID })
+	for i, ef := range m.sorted {
+		if ef.defaultValue.IsValid() {
+			m.defaults = append(m.defaults, ef)
+		}
+		if ef.required >= 0 {
+			m.sorted[i].required = len(m.required)
+			fs[ef.ID] = m.sorted[i]
+			m.required = append(m.required, m.sorted[i])
+		}
+	}
+	m.info = &MessageInfo{Type: t, Name: t.String(), Fields: make([]FieldInfo, len(m.sorted)), Unknown: unknown}
+	for i, ef := range m.sorted {
+		m.info.Fields[i] = ef.FieldInfo
+	}
+	encodeFieldsCache[t] = m
+	return m
+}
+
+func collectFields(root reflect.Type, t reflect.Type, index []int, fs map[int]encodeField, unknown *[]int, visited map[reflect.Type]bool, emitZero bool) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	emitZero = emitZero || structEmitZero(t)
+	n := t.NumField()
 	for i := 0; i < n; i++ {
 		f := t.Field(i)
-		if f.PkgPath != "" {
+		tv := f.Tag.Get("msglib")
+		if tv == "-" {
 			continue
 		}
-		if f.Anonymous {
+		if f.Anonymous && tv == "" {
+			// exported fields of unexported embedded structs are promoted too,
+			// a nil pointer to one fails decoding them, see fieldByIndexAlloc
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectFields(root, ft, appendIndex(index, i), fs, unknown, visited, emitZero)
+			}
 			continue
 		}
-		tv := f.Tag.Get("msglib")
-		if tv == "-" {
+		if f.PkgPath != "" {
+			if f.Anonymous {
+				panic(&UnsupportedValueError{Message: "embedded field " + f.Name + " of " + root.String() + " is unexported and can not be a tagged field"})
+			}
+			continue
+		}
+		if tv == "unknown" {
+			checkUnknownField(root, f, *unknown)
+			*unknown = appendIndex(index, i)
 			continue
 		}
 		if tv != "" {
 			var ef encodeField
-			ef.i = i
+			ef.Index = appendIndex(index, i)
 			id, opts := parseTag(tv)
-			ef.id = id
-			ef.name = f.Name
+			opts, def, hasDefault := opts.cutDefault()
+			ef.ID = id
+			ef.Name = f.Name
+			ef.GoType = f.Type
+			ef.EmitZero = emitZero || opts.Contains("emitzero")
+			ef.required = -1
+			if opts.Contains("required") {
+				ef.EmitZero = true
+				ef.Required = true
+				ef.required = 0 // numbered by encodeFields
+			}
+			if hasDefault {
+				ef.defaultValue = parseDefault(f, def)
+				ef.Default = ef.defaultValue.Interface()
+			}
 			if opts.Contains("set") {
-				ef.fieldType = MT_SET
+				ef.Type = MT_SET
+			} else if opts.Contains("i32") {
+				ef.Type = MT_I32
+			} else if opts.Contains("i64") {
+				ef.Type = MT_I64
 			} else {
-				ef.fieldType = fieldType(f.Type)
+				ef.Type = fieldType(f.Type)
+			}
+			ef.KeyType, ef.ElemType = containerTypes(f.Type, ef.Type)
+			if prev, ok := fs[ef.ID]; ok {
+				panic(&DuplicateFieldError{Type: root, ID: ef.ID, Fields: []string{prev.Name, ef.Name}})
 			}
-			fs[ef.id] = ef
+			fs[ef.ID] = ef
 		}
 	}
-	encodeFieldsCache[t] = m
-	return m
+}
+
+// structEmitZero reports the struct level mode, declared with a blank field
+//
+//	_ struct{} `msglib:",emitzero"`
+func structEmitZero(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "_" {
+			if _, opts := parseTag(f.Tag.Get("msglib")); opts.Contains("emitzero") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func appendIndex(index []int, i int) []int {
+	res := make([]int, len(index)+1)
+	copy(res, index)
+	res[len(index)] = i
+	return res
+}
+
+// omit reports whether a present field value is left out, being zero or equal to the default
+func (ef *encodeField) omit(val reflect.Value) bool {
+	if ef.EmitZero {
+		return false
+	}
+	if ef.defaultValue.IsValid() {
+		return compareValues(val, ef.defaultValue) == 0
+	}
+	return isEmptyValue(val)
+}
+
+// fieldByIndex returns the field of struct val, ok is false when an embedded pointer on the way is nil
+func fieldByIndex(val reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				return reflect.Value{}, false
+			}
+			val = val.Elem()
+		}
+		val = val.Field(x)
+	}
+	return val, true
+}
+
+// fieldByIndexAlloc returns the field of struct val, allocating nil embedded pointers on the way.
+// Like encoding/json, it fails on a nil embedded pointer to an unexported struct, which can not be set.
+func fieldByIndexAlloc(val reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				if !val.CanSet() {
+					msg := "cannot allocate embedded pointer to unexported struct " + val.Type().Elem().String()
+					return reflect.Value{}, &UnsupportedValueError{Value: val, Message: msg}
+				}
+				val.Set(reflect.New(val.Type().Elem()))
+			}
+			val = val.Elem()
+		}
+		val = val.Field(x)
+	}
+	return val, nil
 }
 
 func fieldType(t reflect.Type) byte {
+	if t.Kind() != reflect.Interface && t.Kind() != reflect.Ptr {
+		if t.Implements(typerType) {
+			return reflect.Zero(t).Interface().(Typer).MsglibType()
+		} else if reflect.PtrTo(t).Implements(typerType) {
+			return reflect.New(t).Interface().(Typer).MsglibType()
+		}
+	}
 	switch t.Kind() {
 	case reflect.Bool:
 		return MT_BOOL
@@ -518,9 +573,10 @@ func fieldType(t reflect.Type) byte {
 		return MT_BYTE
 	case reflect.Int16, reflect.Uint16:
 		return MT_I16
-	case reflect.Int32, reflect.Uint32, reflect.Int, reflect.Uint:
+	case reflect.Int32, reflect.Uint32:
 		return MT_I32
-	case reflect.Int64, reflect.Uint64:
+	case reflect.Int64, reflect.Uint64, reflect.Int, reflect.Uint:
+		// int and uint may be 64 bits, use tag option 'i32' for MT_I32
 		return MT_I64
 	case reflect.Float32:
 		return MT_FLOAT
@@ -532,7 +588,7 @@ func fieldType(t reflect.Type) byte {
 			return MT_SET
 		}
 		return MT_MAP
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
 		et := t.Elem()
 		if et.Kind() == reflect.Uint8 {
 			return MT_BINARY
@@ -562,6 +618,59 @@ func parseTag(tag string) (int, tagOptions) {
 	return id, tagOptions("")
 }
 
+// cutDefault splits off the option 'default=', which takes the rest of the tag
+// so that string defaults may contain commas.
+func (o tagOptions) cutDefault() (tagOptions, string, bool) {
+	s := string(o)
+	for start := 0; start < len(s); {
+		if strings.HasPrefix(s[start:], "default=") {
+			return tagOptions(strings.TrimSuffix(s[:start], ",")), s[start+len("default="):], true
+		}
+		i := strings.Index(s[start:], ",")
+		if i < 0 {
+			break
+		}
+		start += i + 1
+	}
+	return o, "", false
+}
+
+// parseDefault parses the default value of a bool, number or string field
+func parseDefault(f reflect.StructField, def string) reflect.Value {
+	val := reflect.New(f.Type).Elem()
+	var err error
+	switch f.Type.Kind() {
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(def); err == nil {
+			val.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(def, 0, f.Type.Bits()); err == nil {
+			val.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(def, 0, f.Type.Bits()); err == nil {
+			val.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		if n, err = strconv.ParseFloat(def, f.Type.Bits()); err == nil {
+			val.SetFloat(n)
+		}
+	case reflect.String:
+		val.SetString(def)
+	default:
+		panic(&UnsupportedValueError{Value: val, Message: "default value of a non scalar field: " + f.Name})
+	}
+	if err != nil {
+		panic(&UnsupportedValueError{Value: val, Message: "invalid default value of field " + f.Name + ": " + err.Error()})
+	}
+	return val
+}
+
 func (o tagOptions) Contains(optionName string) bool {
 	if len(o) == 0 {
 		return false
@@ -581,9 +690,29 @@ func (o tagOptions) Contains(optionName string) bool {
 	return false
 }
 
+func addMissingField(e *MissingFieldError, t reflect.Type, ef encodeField) *MissingFieldError {
+	if e == nil {
+		e = &MissingFieldError{Struct: t.String()}
+	}
+	e.IDs = append(e.IDs, ef.ID)
+	e.Fields = append(e.Fields, ef.Name)
+	return e
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isEmptyValue reports fields left out by default, see encodeFields for presence rules
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
-	case reflect.Array, reflect.Map, reflect.Slice:
+	case reflect.Array:
+		return v.IsZero()
+	case reflect.Map, reflect.Slice:
 		return v.IsNil()
 	case reflect.String:
 		return v.Len() == 0
@@ -600,8 +729,19 @@ func isEmptyValue(v reflect.Value) bool {
 }
 
 func SkipValue(reader io.Reader, proto IMProto, msgtype byte) error {
+	return skipValue(reader, proto, msgtype, nil, 0)
+}
+
+func skipValue(reader io.Reader, proto IMProto, msgtype byte, opts *DecodeOptions, depth int) error {
 	var err error
 	switch msgtype {
+	case MT_STRUCT, MT_MAP, MT_LIST, MT_SET:
+		depth++
+		if err = opts.checkDepth(depth); err != nil {
+			return err
+		}
+	}
+	switch msgtype {
 	case MT_BOOL:
 		_, err = proto.ReadBool(reader)
 	case MT_BYTE:
@@ -617,53 +757,65 @@ func SkipValue(reader io.Reader, proto IMProto, msgtype byte) error {
 	case MT_DOUBLE:
 		_, err = proto.ReadFloat64(reader)
 	case MT_BINARY, MT_STRING:
-		_, err = proto.ReadBinary(reader)
+		var val []byte
+		if val, err = proto.ReadBinary(reader); err == nil {
+			err = opts.checkLength(len(val))
+		}
 	case MT_STRUCT:
 		if _, err := proto.ReadStructBegin(reader); err != nil {
 			return err
 		}
 		for {
-			mfield, err := proto.ReadFieldBegin(reader)
+			mfield, err := readFieldMarker(proto, reader)
 			if err != nil {
 				return err
 			}
 			if mfield.Type == MT_NULL {
 				break
 			}
-			if err = SkipValue(reader, proto, mfield.Type); err != nil {
+			if err = skipValue(reader, proto, mfield.Type, opts, depth); err != nil {
 				return err
 			}
 		}
 	case MT_MAP:
-		mmap, err := proto.ReadMapBegin(reader)
+		mmap, err := readMapMarker(proto, reader)
 		if err != nil {
 			return err
 		}
+		if err = opts.checkCount(mmap.Count); err != nil {
+			return err
+		}
 		for i := 0; i < mmap.Count; i++ {
-			if err = SkipValue(reader, proto, mmap.KeyType); err != nil {
+			if err = skipValue(reader, proto, mmap.KeyType, opts, depth); err != nil {
 				return err
 			}
-			if err = SkipValue(reader, proto, mmap.ValueType); err != nil {
+			if err = skipValue(reader, proto, mmap.ValueType, opts, depth); err != nil {
 				return err
 			}
 		}
 	case MT_LIST:
-		mlist, err := proto.ReadListBegin(reader)
+		mlist, err := readListMarker(proto, reader)
 		if err != nil {
 			return err
 		}
+		if err = opts.checkCount(mlist.Count); err != nil {
+			return err
+		}
 		for i := 0; i < mlist.Count; i++ {
-			if err = SkipValue(reader, proto, mlist.ElementType); err != nil {
+			if err = skipValue(reader, proto, mlist.ElementType, opts, depth); err != nil {
 				return err
 			}
 		}
 	case MT_SET:
-		mset, err := proto.ReadSetBegin(reader)
+		mset, err := readSetMarker(proto, reader)
 		if err != nil {
 			return err
 		}
+		if err = opts.checkCount(mset.Count); err != nil {
+			return err
+		}
 		for i := 0; i < mset.Count; i++ {
-			if err = SkipValue(reader, proto, mset.ElementType); err != nil {
+			if err = skipValue(reader, proto, mset.ElementType, opts, depth); err != nil {
 				return err
 			}
 		}
@@ -1,7 +1,12 @@
 package msglib
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -84,3 +89,501 @@ func TestMsglibCodec(t *testing.T) {
 	}
 	t.Logf("msglib decode/encode struct ok")
 }
+
+// msgTestStamp writes itself as a decimal string
+type msgTestStamp struct {
+	Unix int64
+}
+
+func (s msgTestStamp) MsglibType() byte {
+	return MT_STRING
+}
+
+func (s msgTestStamp) MarshalMsglib(w io.Writer, proto IMProto) error {
+	return proto.WriteString(w, strconv.FormatInt(s.Unix, 10))
+}
+
+func (s *msgTestStamp) UnmarshalMsglib(r io.Reader, proto IMProto) (err error) {
+	str, err := proto.ReadString(r)
+	if err != nil {
+		return err
+	}
+	s.Unix, err = strconv.ParseInt(str, 10, 64)
+	return err
+}
+
+type msgTestMarshaler struct {
+	Created  msgTestStamp             `msglib:"1"`
+	Updated  *msgTestStamp            `msglib:"2"`
+	History  []msgTestStamp           `msglib:"3"`
+	Named    map[string]*msgTestStamp `msglib:"4"`
+	Replaced msgTestStamp             `msglib:"5"`
+}
+
+func TestMsglibMarshaler(t *testing.T) {
+	obj := &msgTestMarshaler{
+		Created: msgTestStamp{Unix: 1500000000},
+		Updated: &msgTestStamp{Unix: 1500000001},
+		History: []msgTestStamp{{Unix: 1}, {Unix: 2}},
+		Named:   map[string]*msgTestStamp{"a": {Unix: 3}},
+	}
+	bytes, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+
+	var obj2 msgTestMarshaler
+	if err = Deserialize(bytes, &obj2); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(obj, &obj2) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, &obj2)
+	}
+
+	// the string payload is visible to the reflective decoder
+	var raw struct {
+		Created string `msglib:"1"`
+	}
+	if err = Deserialize(bytes, &raw); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if raw.Created != "1500000000" {
+		t.Fatalf("data err, expect %v, got %v", "1500000000", raw.Created)
+	}
+
+	// top level marshaler
+	bytes, err = Serialize(msgTestStamp{Unix: 42})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var stamp msgTestStamp
+	if err = Deserialize(bytes, &stamp); err != nil || stamp.Unix != 42 {
+		t.Fatalf("data err, expect %v, got %v, err = %v", 42, stamp.Unix, err)
+	}
+}
+
+type msgTestEnvelope struct {
+	Command int32 `msglib:"1"`
+	Seq     int32 `msglib:"2"`
+}
+
+type msgTestTrace struct {
+	TraceID string `msglib:"10"`
+}
+
+type msgTestEmbedded struct {
+	msgTestEnvelope
+	*msgTestTrace
+	Name string `msglib:"3"`
+}
+
+type msgTestEmbeddedPtr struct {
+	*MsgTestHeader
+	Name string `msglib:"3"`
+}
+
+type MsgTestHeader struct {
+	Command int32 `msglib:"1"`
+}
+
+type msgTestEmbeddedNested struct {
+	MsgTestHeader `msglib:"1"`
+	Name          string `msglib:"3"`
+}
+
+type msgTestEmbeddedFlat struct {
+	Command int32  `msglib:"1"`
+	Seq     int32  `msglib:"2"`
+	Name    string `msglib:"3"`
+}
+
+type msgTestEmbeddedDup struct {
+	msgTestEnvelope
+	Other int32 `msglib:"2"`
+}
+
+type msgTestEmbeddedTagged struct {
+	msgTestTrace `msglib:"4"`
+	Name         string `msglib:"3"`
+}
+
+func TestMsglibEmbedded(t *testing.T) {
+	obj := &msgTestEmbedded{Name: "xixi"}
+	obj.Command = 7
+	obj.Seq = 8
+
+	bytes, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	// promoted fields share the id space of the parent
+	var flat msgTestEmbeddedFlat
+	if err = Deserialize(bytes, &flat); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if flat.Command != 7 || flat.Seq != 8 || flat.Name != "xixi" {
+		t.Fatalf("data err, got %+v", flat)
+	}
+
+	var obj2 msgTestEmbedded
+	if err = Deserialize(bytes, &obj2); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(obj, &obj2) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, &obj2)
+	}
+
+	// exported embedded pointers are allocated when decoding
+	var ptr msgTestEmbeddedPtr
+	if err = Deserialize(bytes, &ptr); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if ptr.MsgTestHeader == nil || ptr.Command != 7 {
+		t.Fatalf("data err, got %+v", ptr)
+	}
+
+	// tagged embedded struct is a nested struct
+	nested := &msgTestEmbeddedNested{Name: "xixi"}
+	nested.Command = 7
+	bytes, err = Serialize(nested)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	if err = Deserialize(bytes, &flat); err == nil {
+		t.Fatalf("expect type mismatch for nested struct")
+	}
+	var nested2 msgTestEmbeddedNested
+	if err = Deserialize(bytes, &nested2); err != nil || !reflect.DeepEqual(nested, &nested2) {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", nested, &nested2, err)
+	}
+
+	// fields of unexported embedded pointers are promoted, the pointer is not allocated
+	traced := &msgTestEmbedded{Name: "x", msgTestTrace: &msgTestTrace{TraceID: "abc"}}
+	bytes, err = Serialize(traced)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	traced2 := msgTestEmbedded{msgTestTrace: &msgTestTrace{}}
+	if err = Deserialize(bytes, &traced2); err != nil || !reflect.DeepEqual(traced, &traced2) {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", traced, &traced2, err)
+	}
+	var uve *UnsupportedValueError
+	if err = Deserialize(bytes, &obj2); !errors.As(err, &uve) {
+		t.Fatalf("expect failure allocating unexported pointer, got %v", err)
+	}
+	if _, err = Serialize(&msgTestEmbeddedTagged{}); !errors.As(err, &uve) {
+		t.Fatalf("expect failure of tagged unexported embedded struct, got %v", err)
+	}
+
+	// duplicated ids
+	_, err = Serialize(&msgTestEmbeddedDup{})
+	if _, ok := err.(*DuplicateFieldError); !ok {
+		t.Fatalf("expect duplicate field error, got %v", err)
+	}
+}
+
+type msgTestArray struct {
+	Hash     [4]byte          `msglib:"1"`
+	Position [3]float32       `msglib:"2"`
+	Tags     [2]*msgTest1_Tag `msglib:"3"`
+}
+
+type msgTestArrayShort struct {
+	Hash     [2]byte    `msglib:"1"`
+	Position [2]float32 `msglib:"2"`
+}
+
+type msgTestArraySlice struct {
+	Hash     []byte    `msglib:"1"`
+	Position []float32 `msglib:"2"`
+}
+
+func TestMsglibArray(t *testing.T) {
+	obj := &msgTestArray{
+		Hash:     [4]byte{1, 2, 3, 4},
+		Position: [3]float32{1.5, -2, 3},
+		Tags:     [2]*msgTest1_Tag{{Val: 1}, {Val: 2}},
+	}
+	bytes, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var obj2 msgTestArray
+	if err = Deserialize(bytes, &obj2); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if !reflect.DeepEqual(obj, &obj2) {
+		t.Fatalf("data err, expect %+v, got %+v", obj, &obj2)
+	}
+
+	// arrays and slices share the wire format
+	var slice msgTestArraySlice
+	if err = Deserialize(bytes, &slice); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if len(slice.Hash) != 4 || len(slice.Position) != 3 || slice.Position[1] != -2 {
+		t.Fatalf("data err, got %+v", slice)
+	}
+
+	var short msgTestArrayShort
+	if err = Deserialize(bytes, &short); err == nil {
+		t.Fatalf("expect array length mismatch")
+	}
+}
+
+type msgTestInt struct {
+	Count  int  `msglib:"1"`
+	Size   uint `msglib:"2"`
+	Legacy int  `msglib:"3,i32"`
+}
+
+type msgTestInt32 struct {
+	Count  int64  `msglib:"1"`
+	Size   uint64 `msglib:"2"`
+	Legacy int32  `msglib:"3"`
+}
+
+type msgTestInt16 struct {
+	Legacy int16 `msglib:"3,i32"`
+}
+
+func TestMsglibIntOverflow(t *testing.T) {
+	obj := &msgTestInt{Count: 1 << 40, Size: 1<<64 - 1, Legacy: -1 << 31}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var obj2 msgTestInt
+	if err = Deserialize(payload, &obj2); err != nil || !reflect.DeepEqual(obj, &obj2) {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", obj, &obj2, err)
+	}
+	var obj3 msgTestInt32
+	if err = Deserialize(payload, &obj3); err != nil || obj3.Count != 1<<40 || obj3.Legacy != -1<<31 {
+		t.Fatalf("data err, got %+v, err = %v", &obj3, err)
+	}
+
+	// encode overflow
+	obj.Legacy = 1 << 31
+	if _, err = Serialize(obj); err == nil {
+		t.Fatalf("expect overflow error")
+	} else if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect overflow error, got %v", err)
+	}
+
+	// decode overflow
+	payload, err = Serialize(&msgTestInt{Legacy: 1 << 20})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var obj4 msgTestInt16
+	if err = Deserialize(payload, &obj4); err == nil {
+		t.Fatalf("expect overflow error, got %+v", obj4)
+	} else if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expect overflow error, got %v", err)
+	}
+
+	// wire overflow
+	buff := &bytes.Buffer{}
+	proto := NewBinaryProto()
+	proto.WriteI64(buff, 1<<40)
+	if _, err = proto.ReadI32(buff); err == nil {
+		t.Fatalf("expect overflow error")
+	}
+}
+
+func TestMsglibSize(t *testing.T) {
+	obj := &msgTest1{Name: "xixi", Age: 300}
+	obj.Tag = &msgTest1_Tag{Val: 242, Hash: bytes.Repeat([]byte("x"), 200)}
+	obj.TagList = []*msgTest1_Tag{{Val: -1}, {Hash: []byte("a")}}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	size, err := Size(obj)
+	if err != nil || size != len(payload) {
+		t.Fatalf("data err, expect size %v, got %v, err = %v", len(payload), size, err)
+	}
+
+	// reserve a header, append without growing the buffer
+	header := []byte{0xca, 0xfe}
+	dst := make([]byte, len(header), len(header)+size)
+	copy(dst, header)
+	frame, err := AppendSerialize(dst, obj)
+	if err != nil {
+		t.Fatalf("append object failure: %+v", err)
+	}
+	if &frame[0] != &dst[0] {
+		t.Fatalf("expect no reallocation")
+	}
+	if !bytes.Equal(frame[:len(header)], header) || len(frame) != len(header)+size {
+		t.Fatalf("data err, expect header and %v bytes, got %v", size, frame)
+	}
+	var obj2 msgTest1
+	if err = Deserialize(frame[len(header):], &obj2); err != nil || !reflect.DeepEqual(obj, &obj2) {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", obj, &obj2, err)
+	}
+
+	// dst is unchanged on error
+	frame, err = AppendSerialize(dst, &msgTestInt{Legacy: 1 << 31})
+	if err == nil || len(frame) != len(header) {
+		t.Fatalf("expect overflow error and unchanged dst, got %v, %v", frame, err)
+	}
+	if _, err = Size(&msgTestInt{Legacy: 1 << 31}); err == nil {
+		t.Fatalf("expect overflow error")
+	}
+}
+
+type msgTestPatch struct {
+	Score int32   `msglib:"1,emitzero"`
+	Name  string  `msglib:"2"`
+	Level *int32  `msglib:"3"`
+	Tags  []int32 `msglib:"4,emitzero"`
+}
+
+type msgTestPatchAll struct {
+	_     struct{} `msglib:",emitzero"`
+	Score int32    `msglib:"1"`
+	Name  string   `msglib:"2"`
+	Level *int32   `msglib:"3"`
+}
+
+type msgTestPresence struct {
+	Score *int32  `msglib:"1"`
+	Name  *string `msglib:"2"`
+	Level *int32  `msglib:"3"`
+}
+
+func TestMsglibEmitZero(t *testing.T) {
+	zero := int32(0)
+	tests := []struct {
+		obj    interface{}
+		expect msgTestPresence
+	}{
+		{&msgTestPatch{}, msgTestPresence{Score: &zero}},
+		{&msgTestPatch{Level: &zero}, msgTestPresence{Score: &zero, Level: &zero}},
+		{&msgTestPatchAll{}, msgTestPresence{Score: &zero, Name: new(string)}},
+		{&msgTestPresence{Level: &zero}, msgTestPresence{Level: &zero}},
+	}
+	for i, test := range tests {
+		payload, err := Serialize(test.obj)
+		if err != nil {
+			t.Fatalf("serialize object failure: %+v", err)
+		}
+		var obj msgTestPresence
+		if err = Deserialize(payload, &obj); err != nil {
+			t.Fatalf("deserialize object failure: %+v", err)
+		}
+		if !reflect.DeepEqual(obj, test.expect) {
+			t.Fatalf("data err, test %v, expect %+v, got %+v", i, test.expect, obj)
+		}
+	}
+
+	// nil slice with emitzero is written as an empty list
+	payload, err := Serialize(&msgTestPatch{})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	expect := []byte{1<<4 | MT_I32, 0, 4<<4 | MT_LIST, MT_I32, MT_NULL}
+	if !bytes.Equal(payload, expect) {
+		t.Fatalf("data err, expect %v, got %v", expect, payload)
+	}
+}
+
+type msgTestDefault struct {
+	HP     int32   `msglib:"1,default=100"`
+	Lang   string  `msglib:"2,default=en,us"`
+	Online bool    `msglib:"3,default=true"`
+	Speed  float32 `msglib:"4,default=1.5"`
+	Level  uint8   `msglib:"5,emitzero,default=0x10"`
+}
+
+type msgTestNoDefault struct {
+	HP     *int32   `msglib:"1"`
+	Lang   *string  `msglib:"2"`
+	Online *bool    `msglib:"3"`
+	Speed  *float32 `msglib:"4"`
+	Level  *uint8   `msglib:"5"`
+}
+
+func TestMsglibDefault(t *testing.T) {
+	// defaults are applied to absent fields
+	var obj msgTestDefault
+	if err := Deserialize([]byte{MT_NULL}, &obj); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	expect := msgTestDefault{HP: 100, Lang: "en,us", Online: true, Speed: 1.5, Level: 16}
+	if obj != expect {
+		t.Fatalf("data err, expect %+v, got %+v", expect, obj)
+	}
+
+	// fields equal to defaults are not written, zero values are
+	payload, err := Serialize(&expect)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var present msgTestNoDefault
+	if err = Deserialize(payload, &present); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if present.HP != nil || present.Lang != nil || present.Online != nil || present.Speed != nil || present.Level == nil {
+		t.Fatalf("data err, expect only Level, got %+v", present)
+	}
+	payload, err = Serialize(&msgTestDefault{})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	obj = msgTestDefault{}
+	if err = Deserialize(payload, &obj); err != nil {
+		t.Fatalf("deserialize object failure: %+v", err)
+	}
+	if obj != (msgTestDefault{}) {
+		t.Fatalf("data err, expect zero values, got %+v", obj)
+	}
+
+	type msgTestBadDefault struct {
+		Tags []string `msglib:"1,default=a"`
+	}
+	if _, err = Serialize(&msgTestBadDefault{}); err == nil {
+		t.Fatalf("expect error for default of a list")
+	}
+}
+
+type msgTestRequired struct {
+	ID   int32         `msglib:"1,required"`
+	Name string        `msglib:"2"`
+	Tag  *msgTest1_Tag `msglib:"3,required"`
+	Note *string       `msglib:"4,required"`
+}
+
+func TestMsglibRequired(t *testing.T) {
+	note := "n"
+	obj := &msgTestRequired{Tag: &msgTest1_Tag{}, Note: &note}
+	payload, err := Serialize(obj)
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	var obj2 msgTestRequired
+	if err = Deserialize(payload, &obj2); err != nil || !reflect.DeepEqual(obj, &obj2) {
+		t.Fatalf("data err, expect %+v, got %+v, err = %v", obj, &obj2, err)
+	}
+
+	// nil required fields are not encoded
+	_, err = Serialize(&msgTestRequired{Note: &note})
+	if me := (*MissingFieldError)(nil); !errors.As(err, &me) || !reflect.DeepEqual(me.IDs, []int{3}) {
+		t.Fatalf("expect missing field 3, got %v", err)
+	}
+
+	// absent required fields
+	err = Deserialize([]byte{MT_NULL}, &msgTestRequired{})
+	if me := (*MissingFieldError)(nil); !errors.As(err, &me) || !reflect.DeepEqual(me.IDs, []int{1, 3, 4}) {
+		t.Fatalf("expect missing fields 1, 3, 4, got %v", err)
+	}
+	payload, err = Serialize(&msgData{Command: 5})
+	if err != nil {
+		t.Fatalf("serialize object failure: %+v", err)
+	}
+	err = Deserialize(payload, &msgTestRequired{})
+	if me := (*MissingFieldError)(nil); !errors.As(err, &me) || !reflect.DeepEqual(me.Fields, []string{"Tag", "Note"}) {
+		t.Fatalf("expect missing fields Tag, Note, got %v", err)
+	}
+}
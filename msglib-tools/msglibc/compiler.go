@@ -3,9 +3,12 @@ package main
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -18,6 +21,7 @@ type MsgCompiler struct {
 	EnumMap     map[string]*EnumSchema
 	ProtoName   string
 	JavaPackage string
+	GoPackage   string
 
 	reader *bytes.Reader
 }
@@ -35,6 +39,9 @@ type FieldSchema struct {
 	FieldID       int
 	Comments      []string
 	SuffixComment string
+	HasDefault    bool
+	DefaultValue  string // proto2 style '[default = ...]', strings are stored unquoted
+	Required      bool   // 'required' label, enforced by generated go code
 }
 
 func NewFieldSchema() *FieldSchema {
@@ -78,6 +85,18 @@ func NewMessageSchema() *MessageSchema {
 	return obj
 }
 
+// RequiredFields returns required fields in ascending id order
+func (self *MessageSchema) RequiredFields() []*FieldSchema {
+	var fields []*FieldSchema
+	for _, field := range self.Fields {
+		if field.Required {
+			fields = append(fields, field)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].FieldID < fields[j].FieldID })
+	return fields
+}
+
 func (self *MessageSchema) GetFieldByID(id int) *FieldSchema {
 	for _, field := range self.Fields {
 		if field.FieldID == id {
@@ -107,6 +126,8 @@ func (self *MsgCompiler) ParseProtoFile(protofile string) error {
 		word := self.NextWord()
 		if word == "javapackage" {
 			self.JavaPackage = self.NextWord()
+		} else if word == "gopackage" {
+			self.GoPackage = self.NextWord()
 		} else if word == "proto" {
 			self.ProtoName = self.NextWord()
 		} else if word == "message" {
@@ -129,6 +150,13 @@ func (self *MsgCompiler) ParseProtoFile(protofile string) error {
 		}
 	}
 
+	for _, msg := range self.Messages {
+		for _, field := range msg.Fields {
+			if err := self.CheckFieldDefault(msg.Name, field); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -309,6 +337,10 @@ func (self *MsgCompiler) ParseField(msgname string, lastComments []string) (*Fie
 		field.Comments = lastComments
 	}
 	field.TypeName = self.NextWord()
+	if field.TypeName == "required" {
+		field.Required = true
+		field.TypeName = self.NextWord()
+	}
 	if field.TypeName == "list" || field.TypeName == "set" {
 		if !self.MatchChar('<') {
 			return nil, errors.New("should be list<T> for field " + field.TypeName + ", msg = " + msgname)
@@ -341,6 +373,11 @@ func (self *MsgCompiler) ParseField(msgname string, lastComments []string) (*Fie
 	if field.FieldID, err = self.NextInteger(); err != nil {
 		return nil, errors.New("Expect integer for field id: " + field.TypeName + " " + field.FieldName + ", msg = " + msgname)
 	}
+	if self.MatchChar('[') {
+		if err = self.ParseFieldOptions(msgname, field); err != nil {
+			return nil, err
+		}
+	}
 	if !self.MatchChar(';') {
 		return nil, errors.New("expect ';' at end of field definition, field: " + field.TypeName +
 			" " + field.FieldName + ", msg = " + msgname)
@@ -353,6 +390,142 @@ func (self *MsgCompiler) ParseField(msgname string, lastComments []string) (*Fie
 	return field, nil
 }
 
+// ParseFieldOptions parses options after '[' of a field, only 'default' is supported
+func (self *MsgCompiler) ParseFieldOptions(msgname string, field *FieldSchema) error {
+	option := self.NextWord()
+	if option != "default" {
+		return errors.New("Unsupported field option '" + option + "', field: " + field.FieldName + ", msg = " + msgname)
+	}
+	if !self.MatchChar('=') {
+		return errors.New("Expect '=' for default value, field: " + field.FieldName + ", msg = " + msgname)
+	}
+	self.SkipWhite()
+	if self.PeekChar() == '"' {
+		str, err := self.NextQuotedString()
+		if err != nil {
+			return errors.New(err.Error() + ", field: " + field.FieldName + ", msg = " + msgname)
+		}
+		field.DefaultValue = str
+	} else {
+		field.DefaultValue = self.NextNumberWord()
+		if field.DefaultValue == "" {
+			return errors.New("Expect default value, field: " + field.FieldName + ", msg = " + msgname)
+		}
+	}
+	field.HasDefault = true
+	if !self.MatchChar(']') {
+		return errors.New("Expect ']' after field options, field: " + field.FieldName + ", msg = " + msgname)
+	}
+	return nil
+}
+
+// NextQuotedString reads a string literal like "en\"us", supporting escapes \\ \" \n \r \t
+func (self *MsgCompiler) NextQuotedString() (string, error) {
+	self.NextChar()
+	var sb []rune
+	for {
+		c := self.NextChar()
+		switch c {
+		case -1, '\n':
+			return "", errors.New("Unterminated string")
+		case '"':
+			return string(sb), nil
+		case '\\':
+			switch e := self.NextChar(); e {
+			case '\\', '"':
+				sb = append(sb, e)
+			case 'n':
+				sb = append(sb, '\n')
+			case 'r':
+				sb = append(sb, '\r')
+			case 't':
+				sb = append(sb, '\t')
+			default:
+				return "", errors.New("Invalid escape in string: \\" + string(e))
+			}
+		default:
+			sb = append(sb, c)
+		}
+	}
+}
+
+// CheckFieldDefault validates the default value against the field type,
+// values must be valid for every generated language.
+func (self *MsgCompiler) CheckFieldDefault(msgname string, field *FieldSchema) error {
+	if !field.HasDefault {
+		return nil
+	}
+	val := field.DefaultValue
+	invalid := func(reason string) error {
+		return errors.New("Invalid default value '" + val + "', " + reason + ", field: " + field.FieldName + ", msg = " + msgname)
+	}
+	if enum, ok := self.EnumMap[field.TypeName]; ok {
+		for _, e := range enum.Fields {
+			if e.FieldName == val {
+				return nil
+			}
+		}
+		return invalid("expect a value of enum " + enum.Name)
+	}
+	var err error
+	switch field.TypeName {
+	case "bool":
+		if val != "true" && val != "false" {
+			return invalid("expect true or false")
+		}
+	case "byte":
+		// java bytes are signed, go and c# bytes unsigned
+		if n, e := strconv.ParseInt(val, 10, 8); e != nil || n < 0 {
+			return invalid("expect an integer in range 0..127")
+		}
+	case "int16":
+		_, err = strconv.ParseInt(val, 10, 16)
+	case "int32":
+		_, err = strconv.ParseInt(val, 10, 32)
+	case "int64":
+		_, err = strconv.ParseInt(val, 10, 64)
+	case "float", "double":
+		var f float64
+		if f, err = strconv.ParseFloat(val, 64); err == nil && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			return invalid("expect a finite number")
+		}
+	case "string":
+	default:
+		return invalid("only bool, number, string and enum fields have defaults")
+	}
+	if err != nil {
+		return invalid(err.Error())
+	}
+	return nil
+}
+
+// quoteString quotes str as a java or c# string literal
+func quoteString(str string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range str {
+		switch c {
+		case '"', '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(c)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&sb, "\\u%04x", c)
+			} else {
+				sb.WriteRune(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
 func (self *MsgCompiler) SkipWhiteAndReturnComments(storeComments bool) ([]string, error) {
 	var comments []string = nil
 	// check comment
@@ -42,7 +42,7 @@ var tmplCSharpCode string = `
         /// {{TrimComment .SuffixComment}}
         /// </summary>
         {{- end}}
-        public {{GetCSharpType .TypeName .TypeParams}} {{.FieldName}};
+        public {{GetCSharpType .TypeName .TypeParams}} {{.FieldName}}{{GetCSharpDefault .}};
 
     {{- end}}{{end}}
     }
@@ -148,6 +148,12 @@ func (self *MsgCompiler) GenerateCSharpCode(outdir string) error {
 		"MakeMioSet": func(field *FieldSchema) string {
 			return self.makeCSharpMioSet(field)
 		},
+		"GetCSharpDefault": func(field *FieldSchema) string {
+			if !field.HasDefault {
+				return ""
+			}
+			return " = " + self.getCSharpDefault(field)
+		},
 	}
 
 	tmpl, err := CreateTemplate(filename, allTemplates, funcMap)
@@ -256,6 +262,14 @@ func (self *MsgCompiler) makeCSharpMioInstV2(typename string) string {
 }
 
 func (self *MsgCompiler) makeCSharpMioGet(field *FieldSchema) string {
+	if field.HasDefault {
+		return fmt.Sprintf("obj.HasField((int)E.%s) ? %s : %s",
+			field.FieldName, self.makeCSharpMioGetValue(field), self.getCSharpDefault(field))
+	}
+	return self.makeCSharpMioGetValue(field)
+}
+
+func (self *MsgCompiler) makeCSharpMioGetValue(field *FieldSchema) string {
 	if self.IsEnumType(field.TypeName) {
 		return fmt.Sprintf("(%s)obj.GetInt((int)E.%s)", field.TypeName, field.FieldName)
 	}
@@ -331,3 +345,20 @@ func (self *MsgCompiler) makeCSharpMioSet(field *FieldSchema) string {
 		return fmt.Sprintf("MIO_%s.I.ToMioObject(data.%s)", typename, fieldname)
 	}
 }
+
+// getCSharpDefault returns the default value of a field as c# expression
+func (self *MsgCompiler) getCSharpDefault(field *FieldSchema) string {
+	val := field.DefaultValue
+	if self.IsEnumType(field.TypeName) {
+		return fmt.Sprintf("%s.%s", field.TypeName, val)
+	}
+	switch field.TypeName {
+	case "string":
+		val = quoteString(val)
+	case "int64":
+		val += "L"
+	case "float":
+		val += "f"
+	}
+	return val
+}
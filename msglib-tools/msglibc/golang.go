@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+/////////////////////////////////////////////////////////////////////// Go Code Generation
+
+var tmplGoCode string = `
+{{- define "Enum"}}
+{{- range $i,$line := .Comments}}
+// {{TrimComment $line }}{{end}}
+type {{GoName .Name}} int32
+
+const (
+{{- $enum := .Name}}
+{{- range $i, $field := .Fields}}{{with $field}}
+	{{- range $i,$line := .Comments}}
+	// {{TrimComment $line }}{{end}}
+	{{GoEnumValue $enum .FieldName}} {{GoName $enum}} = {{.FieldValue}} {{.SuffixComment}}
+{{- end}}{{end}}
+)
+
+func (e {{GoName .Name}}) String() string {
+	switch e {{print "{"}}
+{{- range $i, $field := UniqueEnumFields .}}{{with $field}}
+	case {{GoEnumValue $enum .FieldName}}:
+		return "{{.FieldName}}"
+{{- end}}{{end}}
+	}
+	return "{{GoName .Name}}(" + strconv.Itoa(int(e)) + ")"
+}
+{{- end}}
+{{- define "Message"}}
+{{- range $i,$line := .Comments}}
+// {{TrimComment $line }}{{end}}
+type {{GoName .Name}} struct {{print "{"}}
+{{- range $i, $field := .Fields}}{{with $field}}
+	{{- range $i,$line := .Comments}}
+	// {{TrimComment $line }}{{end}}
+	{{GoName .FieldName}} {{GetGoType .TypeName .TypeParams}} {{GoTag .}} {{.SuffixComment}}
+{{- end}}{{end}}
+}
+
+// MarshalMsglib implements msglib.Marshaler, writing {{GoName .Name}} without reflection.
+func (m *{{GoName .Name}}) MarshalMsglib(w io.Writer, proto msglib.IMProto) error {
+	if m == nil {
+		m = &{{GoName .Name}}{}
+	}
+{{- MakeGoRequiredCheck .}}
+	if err := proto.WriteStructBegin(w, &msglib.MStruct{Name: "{{.Name}}"}); err != nil {
+		return err
+	}
+{{- range $i, $field := .Fields}}
+{{MakeGoWriteField $field}}
+{{- end}}
+	return proto.WriteFieldStop(w)
+}
+
+// UnmarshalMsglib implements msglib.Unmarshaler, unknown fields are skipped.
+func (m *{{GoName .Name}}) UnmarshalMsglib(r io.Reader, proto msglib.IMProto) error {
+	if _, err := proto.ReadStructBegin(r); err != nil {
+		return err
+	}
+{{- range $i, $field := .Fields}}{{if .HasDefault}}
+	m.{{GoName .FieldName}} = {{GoDefault .}}
+{{- end}}{{end}}
+{{- range $i, $field := .RequiredFields}}
+	has{{GoName .FieldName}} := false
+{{- end}}
+	for {
+		field, err := proto.ReadFieldBegin(r)
+		if err != nil {
+			return err
+		}
+		if field.Type == msglib.MT_NULL {{print "{"}}
+{{- MakeGoMissingCheck .}}
+			return nil
+		}
+		switch field.ID {{print "{"}}
+{{- $msg := .Name}}
+{{- range $i, $field := .Fields}}
+		case {{.FieldID}}:
+{{MakeGoReadField $msg $field}}
+{{- end}}
+		default:
+			if err = msglib.SkipValue(r, proto, field.Type); err != nil {
+				return err
+			}
+		}
+	}
+}
+{{- end}}
+{{- define "Main" -}}
+// Code generated by msglibc. DO NOT EDIT.
+
+package {{GoPackage}}
+
+import (
+{{- if HasFields .Messages}}
+	"errors"
+{{- end}}
+	"io"
+{{- if HasEnum .EnumList}}
+	"strconv"
+{{- end}}
+
+	msglib "{{GoMsglibImport}}"
+)
+{{range $idx, $elem := .EnumList}}
+	{{- template "Enum" $elem}}
+{{end}}
+{{range $idx, $elem := .Messages}}
+	{{- template "Message" $elem}}
+{{end}}
+{{- end}}
+{{- template "Main" .}}
+`
+
+func (self *MsgCompiler) GenerateGoCode(outdir string) error {
+	var err error
+	if err = os.MkdirAll(outdir, os.ModePerm); err != nil {
+		return err
+	}
+
+	filename := strings.ToLower(self.ProtoName) + ".go"
+	fullname := path.Join(outdir, filename)
+
+	allTemplates := []string{
+		tmplGoCode,
+	}
+
+	funcMap := template.FuncMap{
+		"HasEnum": func(e []*EnumSchema) bool {
+			return len(e) > 0
+		},
+		"HasFields": func(msgs []*MessageSchema) bool {
+			for _, msg := range msgs {
+				if len(msg.Fields) > 0 {
+					return true
+				}
+			}
+			return false
+		},
+		"TrimComment": func(comment string) string {
+			return trimComment(comment)
+		},
+		"GoPackage": func() string {
+			return self.getGoPackage()
+		},
+		"GoMsglibImport": func() string {
+			return kGoMsglibImport
+		},
+		"GoName": func(name string) string {
+			return goName(name)
+		},
+		"GoEnumValue": func(enumname string, fieldname string) string {
+			return goName(enumname) + "_" + fieldname
+		},
+		"UniqueEnumFields": func(e *EnumSchema) []*EnumFieldSchema {
+			return uniqueEnumFields(e)
+		},
+		"GetGoType": func(typename string, typeparams []string) string {
+			return self.getGoTypeStr(typename, typeparams)
+		},
+		"GoTag": func(field *FieldSchema) string {
+			return self.getGoTag(field)
+		},
+		"GoDefault": func(field *FieldSchema) string {
+			return self.getGoDefault(field)
+		},
+		"MakeGoWriteField": func(field *FieldSchema) string {
+			return self.makeGoWriteField(field)
+		},
+		"MakeGoReadField": func(msgname string, field *FieldSchema) string {
+			return self.makeGoReadField(msgname, field)
+		},
+		"MakeGoRequiredCheck": func(msg *MessageSchema) string {
+			return self.makeGoRequiredCheck(msg)
+		},
+		"MakeGoMissingCheck": func(msg *MessageSchema) string {
+			return self.makeGoMissingCheck(msg)
+		},
+	}
+
+	tmpl, err := CreateTemplate(filename, allTemplates, funcMap)
+	if err != nil {
+		return err
+	}
+
+	buff := &bytes.Buffer{}
+	if err = tmpl.Execute(buff, self); err != nil {
+		return err
+	}
+	code, err := format.Source(buff.Bytes())
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(fullname, code, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generating go code done: %s\n", fullname)
+	return nil
+}
+
+func (self *MsgCompiler) getGoPackage() string {
+	if self.GoPackage != "" {
+		return self.GoPackage
+	}
+	return strings.ToLower(self.ProtoName)
+}
+
+// goName converts proto names like 'player_id' into exported go names like 'PlayerId'
+func goName(name string) string {
+	var sb []rune
+	upper := true
+	for _, c := range name {
+		if c == '_' || c == '.' {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		sb = append(sb, c)
+	}
+	return string(sb)
+}
+
+// uniqueEnumFields drops aliases, go does not allow duplicated switch cases
+func uniqueEnumFields(e *EnumSchema) []*EnumFieldSchema {
+	seen := make(map[int]bool)
+	fields := make([]*EnumFieldSchema, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		if seen[field.FieldValue] {
+			continue
+		}
+		seen[field.FieldValue] = true
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func (self *MsgCompiler) getGoTypeStr(typename string, typeparams []string) string {
+	if self.IsEnumType(typename) {
+		return goName(typename)
+	}
+	switch typename {
+	case "list":
+		if typeparams[0] == "byte" {
+			// msglib writes []byte as MT_BINARY, list<byte> is a list of signed bytes like in java
+			return "[]int8"
+		}
+		return fmt.Sprintf("[]%s", self.getGoTypeStr(typeparams[0], nil))
+	case "set":
+		return fmt.Sprintf("map[%s]struct{}", self.getGoTypeStr(typeparams[0], nil))
+	case "map":
+		return fmt.Sprintf("map[%s]%s", self.getGoTypeStr(typeparams[0], nil), self.getGoTypeStr(typeparams[1], nil))
+	case "bytes":
+		return "[]byte"
+	case "bool":
+		return "bool"
+	case "byte":
+		return "byte"
+	case "string":
+		return "string"
+	case "int16":
+		return "int16"
+	case "int32":
+		return "int32"
+	case "int64":
+		return "int64"
+	case "float":
+		return "float32"
+	case "double":
+		return "float64"
+	default:
+		return "*" + goName(typename)
+	}
+}
+
+func (self *MsgCompiler) getGoMsgType(typename string) string {
+	if self.IsEnumType(typename) {
+		return "msglib.MT_I32"
+	}
+	switch typename {
+	case "list":
+		return "msglib.MT_LIST"
+	case "set":
+		return "msglib.MT_SET"
+	case "map":
+		return "msglib.MT_MAP"
+	case "bytes":
+		return "msglib.MT_BINARY"
+	case "bool":
+		return "msglib.MT_BOOL"
+	case "byte":
+		return "msglib.MT_BYTE"
+	case "string":
+		return "msglib.MT_STRING"
+	case "int16":
+		return "msglib.MT_I16"
+	case "int32":
+		return "msglib.MT_I32"
+	case "int64":
+		return "msglib.MT_I64"
+	case "float":
+		return "msglib.MT_FLOAT"
+	case "double":
+		return "msglib.MT_DOUBLE"
+	}
+	return "msglib.MT_STRUCT"
+}
+
+// getGoProtoMethod returns the IMProto method suffix for primitive types
+func getGoProtoMethod(typename string) string {
+	switch typename {
+	case "bytes":
+		return "Binary"
+	case "bool":
+		return "Bool"
+	case "byte":
+		return "Byte"
+	case "string":
+		return "String"
+	case "int16":
+		return "I16"
+	case "int32":
+		return "I32"
+	case "int64":
+		return "I64"
+	case "float":
+		return "Float32"
+	case "double":
+		return "Float64"
+	}
+	return ""
+}
+
+// getGoTag returns the msglib struct tag, defaults are numeric for enums
+func (self *MsgCompiler) getGoTag(field *FieldSchema) string {
+	opts := strconv.Itoa(field.FieldID)
+	if field.Required {
+		opts += ",required"
+	}
+	if field.HasDefault {
+		val := field.DefaultValue
+		if enum, ok := self.EnumMap[field.TypeName]; ok {
+			for _, e := range enum.Fields {
+				if e.FieldName == val {
+					val = strconv.Itoa(e.FieldValue)
+					break
+				}
+			}
+		}
+		opts += ",default=" + val
+	}
+	tag := "msglib:" + strconv.Quote(opts)
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
+}
+
+// getGoDefault returns the default value of a field as go expression
+func (self *MsgCompiler) getGoDefault(field *FieldSchema) string {
+	if self.IsEnumType(field.TypeName) {
+		return goName(field.TypeName) + "_" + field.DefaultValue
+	}
+	if field.TypeName == "string" {
+		return strconv.Quote(field.DefaultValue)
+	}
+	return field.DefaultValue
+}
+
+// getGoEmptyCheck mirrors msglib.isEmptyValue, empty fields are not written.
+// Fields with a default are not written when they equal the default, required fields are always written.
+func (self *MsgCompiler) getGoEmptyCheck(field *FieldSchema) string {
+	name := "m." + goName(field.FieldName)
+	if field.Required {
+		return ""
+	}
+	if field.HasDefault {
+		switch {
+		case field.TypeName != "bool":
+			return name + " != " + self.getGoDefault(field)
+		case field.DefaultValue == "true":
+			return "!" + name
+		default:
+			return name
+		}
+	}
+	if self.IsEnumType(field.TypeName) {
+		return name + " != 0"
+	}
+	switch field.TypeName {
+	case "bool":
+		return ""
+	case "string":
+		return name + ` != ""`
+	case "byte", "int16", "int32", "int64", "float", "double":
+		return name + " != 0"
+	}
+	return name + " != nil"
+}
+
+// makeGoWriteValue writes a non-collection value
+func (self *MsgCompiler) makeGoWriteValue(expr string, typename string) string {
+	if self.IsEnumType(typename) {
+		return fmt.Sprintf("if err := proto.WriteI32(w, int32(%s)); err != nil {\nreturn err\n}\n", expr)
+	}
+	if method := getGoProtoMethod(typename); method != "" {
+		return fmt.Sprintf("if err := proto.Write%s(w, %s); err != nil {\nreturn err\n}\n", method, expr)
+	}
+	return fmt.Sprintf("if err := %s.MarshalMsglib(w, proto); err != nil {\nreturn err\n}\n", expr)
+}
+
+// makeGoReadValue reads a non-collection value into target
+func (self *MsgCompiler) makeGoReadValue(target string, typename string) string {
+	if self.IsEnumType(typename) {
+		return fmt.Sprintf("if n, err := proto.ReadI32(r); err != nil {\nreturn err\n} else {\n%s = %s(n)\n}\n",
+			target, goName(typename))
+	}
+	if method := getGoProtoMethod(typename); method != "" {
+		return fmt.Sprintf("if %s, err = proto.Read%s(r); err != nil {\nreturn err\n}\n", target, method)
+	}
+	return fmt.Sprintf("%s = &%s{}\nif err = %s.UnmarshalMsglib(r, proto); err != nil {\nreturn err\n}\n",
+		target, goName(typename), target)
+}
+
+func (self *MsgCompiler) makeGoWriteField(field *FieldSchema) string {
+	name := "m." + goName(field.FieldName)
+	buff := &bytes.Buffer{}
+	check := self.getGoEmptyCheck(field)
+	if check != "" {
+		fmt.Fprintf(buff, "if %s {\n", check)
+	}
+	fmt.Fprintf(buff, "if err := proto.WriteFieldBegin(w, &msglib.MField{Name: %q, Type: %s, ID: %d}); err != nil {\nreturn err\n}\n",
+		goName(field.FieldName), self.getGoMsgType(field.TypeName), field.FieldID)
+	switch field.TypeName {
+	case "list":
+		fmt.Fprintf(buff, "if err := proto.WriteListBegin(w, &msglib.MList{ElementType: %s, Count: len(%s)}); err != nil {\nreturn err\n}\n",
+			self.getGoMsgType(field.TypeParams[0]), name)
+		elem := "e"
+		if field.TypeParams[0] == "byte" {
+			elem = "byte(e)"
+		}
+		fmt.Fprintf(buff, "for _, e := range %s {\n%s}\n", name, self.makeGoWriteValue(elem, field.TypeParams[0]))
+	case "set":
+		fmt.Fprintf(buff, "if err := proto.WriteSetBegin(w, &msglib.MSet{ElementType: %s, Count: len(%s)}); err != nil {\nreturn err\n}\n",
+			self.getGoMsgType(field.TypeParams[0]), name)
+		fmt.Fprintf(buff, "for k := range %s {\n%s}\n", name, self.makeGoWriteValue("k", field.TypeParams[0]))
+	case "map":
+		fmt.Fprintf(buff, "if err := proto.WriteMapBegin(w, &msglib.MMap{KeyType: %s, ValueType: %s, Count: len(%s)}); err != nil {\nreturn err\n}\n",
+			self.getGoMsgType(field.TypeParams[0]), self.getGoMsgType(field.TypeParams[1]), name)
+		fmt.Fprintf(buff, "for k, v := range %s {\n%s%s}\n", name,
+			self.makeGoWriteValue("k", field.TypeParams[0]), self.makeGoWriteValue("v", field.TypeParams[1]))
+	default:
+		buff.WriteString(self.makeGoWriteValue(name, field.TypeName))
+	}
+	if check != "" {
+		buff.WriteString("}\n")
+	}
+	return strings.TrimSuffix(buff.String(), "\n")
+}
+
+func (self *MsgCompiler) makeGoReadField(msgname string, field *FieldSchema) string {
+	name := "m." + goName(field.FieldName)
+	mismatch := fmt.Sprintf("return errors.New(%q)\n",
+		"msglib: type mismatch: "+goName(msgname)+", field: "+goName(field.FieldName))
+	buff := &bytes.Buffer{}
+	fmt.Fprintf(buff, "if field.Type != %s {\n%s}\n", self.getGoMsgType(field.TypeName), mismatch)
+	switch field.TypeName {
+	case "list":
+		elemtype := field.TypeParams[0]
+		fmt.Fprintf(buff, "list, err := proto.ReadListBegin(r)\nif err != nil {\nreturn err\n}\n")
+		fmt.Fprintf(buff, "if list.Count > 0 && list.ElementType != %s {\n%s}\n", self.getGoMsgType(elemtype), mismatch)
+		elem := "e"
+		if elemtype == "byte" {
+			elem = "int8(e)"
+		}
+		fmt.Fprintf(buff, "%s = nil\nfor i := 0; i < list.Count; i++ {\nvar e %s\n%s%s = append(%s, %s)\n}\n",
+			name, self.getGoTypeStr(elemtype, nil), self.makeGoReadValue("e", elemtype), name, name, elem)
+	case "set":
+		elemtype := field.TypeParams[0]
+		fmt.Fprintf(buff, "set, err := proto.ReadSetBegin(r)\nif err != nil {\nreturn err\n}\n")
+		fmt.Fprintf(buff, "if set.Count > 0 && set.ElementType != %s {\n%s}\n", self.getGoMsgType(elemtype), mismatch)
+		fmt.Fprintf(buff, "%s = make(%s)\nfor i := 0; i < set.Count; i++ {\nvar k %s\n%s%s[k] = struct{}{}\n}\n",
+			name, self.getGoTypeStr(field.TypeName, field.TypeParams), self.getGoTypeStr(elemtype, nil),
+			self.makeGoReadValue("k", elemtype), name)
+	case "map":
+		keytype, valtype := field.TypeParams[0], field.TypeParams[1]
+		fmt.Fprintf(buff, "mmap, err := proto.ReadMapBegin(r)\nif err != nil {\nreturn err\n}\n")
+		fmt.Fprintf(buff, "if mmap.Count > 0 && (mmap.KeyType != %s || mmap.ValueType != %s) {\n%s}\n",
+			self.getGoMsgType(keytype), self.getGoMsgType(valtype), mismatch)
+		fmt.Fprintf(buff, "%s = make(%s)\nfor i := 0; i < mmap.Count; i++ {\nvar k %s\nvar v %s\n%s%s%s[k] = v\n}\n",
+			name, self.getGoTypeStr(field.TypeName, field.TypeParams),
+			self.getGoTypeStr(keytype, nil), self.getGoTypeStr(valtype, nil),
+			self.makeGoReadValue("k", keytype), self.makeGoReadValue("v", valtype), name)
+	default:
+		buff.WriteString(self.makeGoReadValue(name, field.TypeName))
+	}
+	if field.Required {
+		fmt.Fprintf(buff, "has%s = true\n", goName(field.FieldName))
+	}
+	return strings.TrimSuffix(buff.String(), "\n")
+}
+
+// makeGoMissing returns code collecting fields whose condition is true into msglib.MissingFieldError
+func (self *MsgCompiler) makeGoMissing(msg *MessageSchema, fields []*FieldSchema, conds []string) string {
+	buff := &bytes.Buffer{}
+	fmt.Fprintf(buff, "\nif %s {\n", strings.Join(conds, " || "))
+	fmt.Fprintf(buff, "missing := &msglib.MissingFieldError{Struct: %q}\n", self.getGoPackage()+"."+goName(msg.Name))
+	for i, field := range fields {
+		fmt.Fprintf(buff, "if %s {\nmissing.IDs = append(missing.IDs, %d)\nmissing.Fields = append(missing.Fields, %q)\n}\n",
+			conds[i], field.FieldID, goName(field.FieldName))
+	}
+	buff.WriteString("return missing\n}")
+	return buff.String()
+}
+
+// makeGoRequiredCheck refuses to write messages with nil required fields,
+// required fields of other types are always written
+func (self *MsgCompiler) makeGoRequiredCheck(msg *MessageSchema) string {
+	var fields []*FieldSchema
+	var conds []string
+	for _, field := range msg.RequiredFields() {
+		if strings.HasPrefix(self.getGoTypeStr(field.TypeName, field.TypeParams), "*") {
+			fields = append(fields, field)
+			conds = append(conds, "m."+goName(field.FieldName)+" == nil")
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return self.makeGoMissing(msg, fields, conds)
+}
+
+// makeGoMissingCheck reports required fields absent from the decoded message
+func (self *MsgCompiler) makeGoMissingCheck(msg *MessageSchema) string {
+	fields := msg.RequiredFields()
+	if len(fields) == 0 {
+		return ""
+	}
+	conds := make([]string, len(fields))
+	for i, field := range fields {
+		conds[i] = "!has" + goName(field.FieldName)
+	}
+	return self.makeGoMissing(msg, fields, conds)
+}
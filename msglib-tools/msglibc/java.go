@@ -82,7 +82,7 @@ var tmplJavaCode string = `
         {{- $extra := GetJavaExtraAnnotation .TypeName .TypeParams}}{{if eq $extra "" | not}}
         {{$extra}}
         {{- end}}
-        public {{GetJavaType .TypeName .TypeParams}} {{.FieldName}};
+        public {{GetJavaType .TypeName .TypeParams}} {{.FieldName}}{{GetJavaDefault .}};
 
     {{- end}}{{end}}
     }
@@ -167,6 +167,9 @@ func (self *MsgCompiler) GenerateJavaCode(outdir string) error {
 			res := self.getJavaExtraAnnotation(typename, typeparams)
 			return res
 		},
+		"GetJavaDefault": func(field *FieldSchema) string {
+			return self.getJavaDefault(field)
+		},
 	}
 
 	tmpl, err := CreateTemplate(filename, allTemplates, funcMap)
@@ -251,3 +254,23 @@ func (self *MsgCompiler) getJavaExtraAnnotation(typename string, typeparams []st
 	}
 	return ""
 }
+
+// getJavaDefault returns the field initializer of fields with a default value
+func (self *MsgCompiler) getJavaDefault(field *FieldSchema) string {
+	if !field.HasDefault {
+		return ""
+	}
+	val := field.DefaultValue
+	if self.IsEnumType(field.TypeName) {
+		return fmt.Sprintf(" = %s.%s.getCode()", field.TypeName, val)
+	}
+	switch field.TypeName {
+	case "string":
+		val = quoteString(val)
+	case "int64":
+		val += "L"
+	case "float":
+		val += "f"
+	}
+	return " = " + val
+}
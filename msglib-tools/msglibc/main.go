@@ -10,6 +10,7 @@ const (
 	kVersion         = "1.0.0 (by xuwaters@gmail.com)"
 	kErrCodeEnumName = "ErrCode"
 	kCSharpFilename  = "messages.cs"
+	kGoMsglibImport  = "github.com/xuwaters/msglib/msglib-go"
 )
 
 func main() {
@@ -22,7 +23,7 @@ func main() {
 	)
 	flag.BoolVar(&PrintVersion, "version", false, "Print version")
 	flag.StringVar(&ProtoFile, "proto", "", "'.proto' file path")
-	flag.StringVar(&Language, "language", "", "generated language, supported options: java, csharp")
+	flag.StringVar(&Language, "language", "", "generated language, supported options: java, csharp, go")
 	flag.StringVar(&Outdir, "outdir", "out", "output directory for generated source code")
 	flag.StringVar(&ErrCodeFile, "errors", "", "output file for error texts, empty value indicates do not generate error texts")
 	flag.Parse()
@@ -52,6 +53,8 @@ func main() {
 		err = compiler.GenerateJavaCode(Outdir)
 	case "csharp":
 		err = compiler.GenerateCSharpCode(Outdir)
+	case "go":
+		err = compiler.GenerateGoCode(Outdir)
 	default:
 		fmt.Fprintf(os.Stderr, "Unsupported language %s\n", Language)
 		return